@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	Snapshot     = admin.Snapshot
+	Registration = admin.Registration
+	Target       = admin.Target
+	Address      = admin.Address
+	Balancer     = admin.Balancer
+	Peer         = admin.Peer
+)
+
+// State returns the apps registered by the registries of the process, the
+// addresses of every resolver and the peers of every balancer.
+func State() Snapshot {
+	return admin.State()
+}
+
+// Handler serves the State as a html page, or as json with ?format=json or
+// an Accept: application/json header, e.g.
+// http.Handle("/debug/grpc-lb", admin.Handler())
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := State()
+		if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(s)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, s); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+var page = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>grpc-lb</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<p><a href="?format=json">json</a></p>
+
+<h2>Registrations</h2>
+<table>
+<tr><th>registry</th><th>service</th><th>addr</th><th>status</th><th>metadata</th><th>registered</th><th>last renew</th><th>renew failures</th></tr>
+{{range .Registrations}}
+<tr>
+<td>{{.Registry}}</td><td>{{.Service}}</td><td>{{.Addr}}</td><td>{{.Status}}</td>
+<td>{{range $k, $v := .Metadata}}{{$k}}={{$v}}<br>{{end}}</td>
+<td>{{time .RegisteredAt}}</td><td>{{time .LastRenew}}</td>
+<td>{{.RenewFailures}}{{if .LastError}} <span class="error">{{.LastError}}</span>{{end}}</td>
+</tr>
+{{end}}
+</table>
+
+<h2>Resolvers</h2>
+<table>
+<tr><th>target</th><th>addresses</th><th>updates</th><th>last update</th><th>last error</th></tr>
+{{range .Resolvers}}
+<tr>
+<td>{{.Scheme}}:///{{.Target}}</td>
+<td>{{range .Addresses}}{{.Addr}}{{range $k, $v := .Metadata}} {{$k}}={{$v}}{{end}}<br>{{end}}</td>
+<td>{{.Updates}}</td><td>{{time .LastUpdate}}</td>
+<td class="error">{{.LastError}}</td>
+</tr>
+{{end}}
+</table>
+
+<h2>Balancers</h2>
+{{range .Balancers}}
+<h3>{{.Balancer}} {{.Target}}</h3>
+<table>
+<tr><th>addr</th><th>weight</th><th>current weight</th><th>breaker</th><th>details</th></tr>
+{{range .Peers}}
+<tr>
+<td>{{.Addr}}</td><td>{{.Weight}}</td><td>{{.CurrentWeight}}</td>
+<td{{if eq .Breaker "open"}} class="error"{{end}}>{{.Breaker}}</td>
+<td>{{range $k, $v := .Details}}{{$k}}={{$v}} {{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,129 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"github.com/liuxp0827/grpc-lb/admin"
+	"github.com/liuxp0827/grpc-lb/app"
+	internaladmin "github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/balancer/smooth_weighted"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"google.golang.org/grpc"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/liuxp0827/grpc-lb/resolver/memory"
+)
+
+func TestHandler(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	go s.Serve(lis)
+	defer s.Stop()
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	a := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: port, Metadata: app.Metadata{"weight": "10"}}
+	r := memory.New(memory.WithStore(memory.NewStore(t.Name())), memory.WithTTL(time.Millisecond*30))
+	defer r.Close()
+	r.Register(a)
+
+	conn, err := grpc.Dial("memory://"+t.Name()+"/dev/echo", grpc.WithInsecure(), grpc.WithBalancerName(smooth_weighted.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	state := func(done func(s admin.Snapshot) bool) admin.Snapshot {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second * 5); ; {
+			rec := httptest.NewRecorder()
+			admin.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/?format=json", nil))
+			var s admin.Snapshot
+			if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+				t.Fatal(err)
+			}
+			if done(s) {
+				return s
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for the state, got %+v", s)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	// 等待续约和picker的创建
+	got := state(func(s admin.Snapshot) bool {
+		return len(s.Registrations) == 1 && !s.Registrations[0].LastRenew.IsZero() &&
+			len(s.Balancers) == 1 && len(s.Balancers[0].Peers) == 1
+	})
+
+	addr := lis.Addr().String()
+	reg := got.Registrations[0]
+	if reg.Registry != "memory" || reg.Service != "dev/echo" || reg.Addr != addr || reg.Status != "up" {
+		t.Errorf("registration = %+v", reg)
+	}
+	if len(got.Resolvers) != 1 || got.Resolvers[0].Scheme != "memory" || len(got.Resolvers[0].Addresses) != 1 ||
+		got.Resolvers[0].Addresses[0].Metadata["weight"] != "10" {
+		t.Errorf("resolvers = %+v", got.Resolvers)
+	}
+	b := got.Balancers[0]
+	if b.Balancer != smooth_weighted.Name || b.Peers[0].Addr != addr || b.Peers[0].Weight != 10 || b.Peers[0].Breaker != "closed" {
+		t.Errorf("balancer = %+v", b)
+	}
+
+	rec := httptest.NewRecorder()
+	admin.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), addr) {
+		t.Errorf("html has no %s:\n%s", addr, rec.Body.String())
+	}
+
+	if err := registry.Drain(r, a); err != nil {
+		t.Fatal(err)
+	}
+	state(func(s admin.Snapshot) bool {
+		return len(s.Registrations) == 1 && s.Registrations[0].Status == app.StatusDraining
+	})
+
+	// 关闭后不再上报
+	conn.Close()
+	r.Close()
+	state(func(s admin.Snapshot) bool {
+		return len(s.Registrations) == 0 && len(s.Resolvers) == 0 && len(s.Balancers) == 0
+	})
+}
+
+func TestRegisteredSameAddr(t *testing.T) {
+	echo := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 9090}
+	hello := app.App{Env: "dev", Name: "hello", Addr: "127.0.0.1", Port: 9090}
+	unregisterEcho := internaladmin.Registered("test", echo)
+	unregisterHello := internaladmin.Registered("test", hello)
+	defer unregisterHello()
+
+	services := func() []string {
+		var services []string
+		for _, reg := range admin.State().Registrations {
+			if reg.Registry == "test" {
+				services = append(services, reg.Service)
+			}
+		}
+		return services
+	}
+	if got := services(); len(got) != 2 {
+		t.Fatalf("services = %v, want dev/echo and dev/hello", got)
+	}
+
+	// 注销只删除自己的注册，包括被之后的注册替换的情况
+	unregisterEcho()
+	again := internaladmin.Registered("test", hello)
+	unregisterHello()
+	if got := services(); len(got) != 1 || got[0] != "dev/hello" {
+		t.Errorf("services = %v, want dev/hello", got)
+	}
+	again()
+}
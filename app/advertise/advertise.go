@@ -0,0 +1,204 @@
+package advertise
+
+import (
+	"errors"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"net"
+	"os"
+)
+
+var ErrNoAddress = errors.New("advertise: no address to advertise")
+
+var (
+	// EnvKeys are the environment variables holding the address to advertise,
+	// the first one set is used
+	EnvKeys = []string{"POD_IP", "HOST_IP"}
+	// DevEnvs are the app.Env where a loopback address may be advertised
+	DevEnvs = []string{"dev", "local", "test"}
+)
+
+// FromListener advertises the port of the listener, and its address if it
+// is not a wildcard one
+func FromListener(lis net.Listener) Option {
+	return func(opts *Options) {
+		opts.lis = lis
+	}
+}
+
+// WithInterface advertises an address of the network interface, e.g. eth0
+func WithInterface(name string) Option {
+	return func(opts *Options) {
+		opts.iface = name
+	}
+}
+
+// WithCIDR advertises an address in the first of the networks found on the
+// interfaces, e.g. 10.0.0.0/8
+func WithCIDR(cidrs ...string) Option {
+	return func(opts *Options) {
+		opts.cidrs = cidrs
+	}
+}
+
+type Option func(opts *Options)
+type Options struct {
+	lis   net.Listener
+	iface string
+	cidrs []string
+}
+
+// Fill sets a.Addr and a.Port if they are empty, the address is the first
+// one found of
+//   - the address of the listener, unless it is a loopback one and a.Env is
+//     not one of DevEnvs
+//   - the interface of WithInterface, in the networks of WithCIDR if any
+//   - an interface address in the networks of WithCIDR
+//   - the environment variables EnvKeys
+//   - the first global unicast address of the interfaces, ipv4 preferred
+//
+// The port is the one of the listener. Wildcard addresses are rejected, and
+// loopback ones unless a.Env is one of DevEnvs.
+func Fill(a *app.App, opts ...Option) error {
+	o := new(Options)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if a.Port == 0 && o.lis != nil {
+		if addr, ok := o.lis.Addr().(*net.TCPAddr); ok {
+			a.Port = addr.Port
+		}
+	}
+	if a.Port == 0 {
+		return fmt.Errorf("advertise: no port to advertise for %s", a.Name)
+	}
+
+	if a.Addr == "" {
+		ip, err := detect(o, dev(a.Env))
+		if err != nil {
+			return err
+		}
+		a.Addr = ip.String()
+	}
+
+	return Check(*a)
+}
+
+// Check rejects the app if its address is not routable from other hosts
+func Check(a app.App) error {
+	ip := net.ParseIP(a.Addr)
+	if ip == nil { // 主机名不检查
+		return nil
+	}
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("advertise: %s of %s is not routable", a.Addr, a.Name)
+	}
+	if ip.IsLoopback() && !dev(a.Env) {
+		return fmt.Errorf("advertise: loopback address %s of %s in env %s", a.Addr, a.Name, a.Env)
+	}
+	return nil
+}
+
+func dev(env string) bool {
+	for _, e := range DevEnvs {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
+
+// detect finds the address to advertise, loopback only tells if the address
+// of the listener may be a loopback one
+func detect(o *Options, loopback bool) (net.IP, error) {
+	if o.lis != nil {
+		// 非开发环境监听loopback地址时使用其余的来源
+		if addr, ok := o.lis.Addr().(*net.TCPAddr); ok && addr.IP != nil && !addr.IP.IsUnspecified() && (loopback || !addr.IP.IsLoopback()) {
+			return addr.IP, nil
+		}
+	}
+
+	nets := make([]*net.IPNet, 0, len(o.cidrs))
+	for _, cidr := range o.cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("advertise: invalid cidr %q: %v", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+
+	var ips []net.IP
+	if o.iface != "" {
+		iface, err := net.InterfaceByName(o.iface)
+		if err != nil {
+			return nil, err
+		}
+		if ips, err = interfaceIPs(*iface); err != nil {
+			return nil, err
+		}
+	} else {
+		// 没有指定网卡和网段时，环境变量优先于网卡地址
+		if len(nets) == 0 {
+			for _, key := range EnvKeys {
+				if v := os.Getenv(key); v != "" {
+					ip := net.ParseIP(v)
+					if ip == nil {
+						return nil, fmt.Errorf("advertise: invalid ip %q in %s", v, key)
+					}
+					return ip, nil
+				}
+			}
+		}
+
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+				continue
+			}
+			addrs, err := interfaceIPs(iface)
+			if err != nil {
+				return nil, err
+			}
+			ips = append(ips, addrs...)
+		}
+	}
+
+	if len(nets) > 0 {
+		for _, n := range nets {
+			for _, ip := range ips {
+				if n.Contains(ip) {
+					return ip, nil
+				}
+			}
+		}
+		return nil, ErrNoAddress
+	}
+
+	// 优先使用ipv4地址
+	for _, v4 := range []bool{true, false} {
+		for _, ip := range ips {
+			if ip.IsGlobalUnicast() && (ip.To4() != nil) == v4 {
+				return ip, nil
+			}
+		}
+	}
+	return nil, ErrNoAddress
+}
+
+func interfaceIPs(iface net.Interface) ([]net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, n.IP)
+		}
+	}
+	return ips, nil
+}
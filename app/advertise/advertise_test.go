@@ -0,0 +1,107 @@
+package advertise
+
+import (
+	"github.com/liuxp0827/grpc-lb/app"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestFill(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	port := lis.Addr().(*net.TCPAddr).Port
+
+	a := app.App{Env: "dev", Name: "echo"}
+	if err := Fill(&a, FromListener(lis)); err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr != "127.0.0.1" || a.Port != port {
+		t.Errorf("addr = %s:%d, want 127.0.0.1:%d", a.Addr, a.Port, port)
+	}
+
+	// 非开发环境不允许注册loopback地址
+	a = app.App{Env: "prod", Name: "echo", Addr: "127.0.0.1", Port: 8080}
+	if err := Fill(&a); err == nil {
+		t.Errorf("loopback address %s accepted in prod", a.Addr)
+	}
+
+	a = app.App{Env: "prod", Name: "echo", Addr: "0.0.0.0", Port: 8080}
+	if err := Fill(&a); err == nil {
+		t.Error("wildcard address accepted")
+	}
+
+	os.Setenv("POD_IP", "10.1.2.3")
+	defer os.Unsetenv("POD_IP")
+
+	// 监听的地址优先于环境变量
+	a = app.App{Env: "dev", Name: "echo"}
+	if err := Fill(&a, FromListener(lis)); err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr != "127.0.0.1" {
+		t.Errorf("addr = %s, want the one of the listener", a.Addr)
+	}
+
+	// 非开发环境监听loopback地址时使用其余的来源
+	a = app.App{Env: "prod", Name: "echo"}
+	if err := Fill(&a, FromListener(lis)); err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr != "10.1.2.3" || a.Port != port {
+		t.Errorf("addr = %s:%d, want 10.1.2.3:%d", a.Addr, a.Port, port)
+	}
+
+	// 监听通配地址时使用环境变量
+	wildcard, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wildcard.Close()
+	a = app.App{Env: "prod", Name: "echo"}
+	if err := Fill(&a, FromListener(wildcard)); err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr != "10.1.2.3" {
+		t.Errorf("addr = %s, want the one of POD_IP", a.Addr)
+	}
+
+	// 指定的网段优先于环境变量
+	ip := localIP(t)
+	if ip == nil {
+		return
+	}
+	a = app.App{Env: "dev", Name: "echo", Port: 8080}
+	if err := Fill(&a, WithCIDR(ip.String()+"/32")); err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr != ip.String() {
+		t.Errorf("addr = %s, want %s in the cidr", a.Addr, ip)
+	}
+}
+
+// localIP returns an ipv4 address of the interfaces, nil if there is none
+func localIP(t *testing.T) net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ips, err := interfaceIPs(iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
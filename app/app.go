@@ -6,18 +6,29 @@ import (
 
 type Metadata map[string]string
 
+// status of a registered app, resolvers stop sending new rpcs to draining apps
+const (
+	StatusUp       = ""
+	StatusDraining = "draining"
+)
+
 type App struct {
 	Env      string   `json:"env"`
 	Name     string   `json:"name"`
 	Addr     string   `json:"addr"`
 	Port     int      `json:"port"`
 	Metadata Metadata `json:"metadata"`
+	Status   string   `json:"status,omitempty"`
 }
 
 func (m Metadata) ToMap() map[string]string {
 	return map[string]string(m)
 }
 
+func (a *App) Draining() bool {
+	return a.Status == StatusDraining
+}
+
 func (a *App) Encode() string {
 	byts, _ := json.Marshal(a)
 	return string(byts)
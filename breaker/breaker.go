@@ -0,0 +1,181 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int32
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type Config struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker, 0 disables the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting probes through.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes allowed at once while half-open,
+	// all of them have to succeed to close the breaker.
+	HalfOpenRequests int
+}
+
+var DefaultConfig = Config{
+	FailureThreshold: 5,
+	OpenTimeout:      time.Second * 10,
+	HalfOpenRequests: 1,
+}
+
+// OnStateChange is called on every state transition of any breaker, with the
+// name the breaker was created with. It should be set before the breakers are
+// used, e.g. to export the states as metrics.
+var OnStateChange func(name string, from, to State)
+
+type Breaker struct {
+	name string
+
+	mu       sync.Mutex
+	cfg      Config
+	state    State
+	failures int
+	probes   int // probes in flight while half-open
+	passed   int // succeeded probes while half-open
+	openedAt time.Time
+}
+
+func New(name string, cfg Config) *Breaker {
+	return &Breaker{
+		name: name,
+		cfg:  cfg,
+	}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// SetConfig changes the config in place, a disabled breaker is closed.
+func (b *Breaker) SetConfig(cfg Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cfg = cfg
+	if cfg.FailureThreshold <= 0 {
+		b.setState(StateClosed)
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Ready reports whether a request would be allowed, without taking a probe slot.
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		return time.Since(b.openedAt) >= b.cfg.OpenTimeout
+	case StateHalfOpen:
+		return b.probes < b.halfOpenRequests()
+	}
+	return true
+}
+
+// Allow reports whether a request may go through, every allowed request
+// must be followed by a call to Done.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+		b.setState(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if b.probes >= b.halfOpenRequests() {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// Done records the outcome of an allowed request.
+func (b *Breaker) Done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cfg.FailureThreshold <= 0 {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		b.probes--
+		if failed {
+			b.setState(StateOpen)
+			return
+		}
+		b.passed++
+		if b.passed >= b.halfOpenRequests() {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+func (b *Breaker) halfOpenRequests() int {
+	if b.cfg.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return b.cfg.HalfOpenRequests
+}
+
+func (b *Breaker) setState(s State) {
+	from := b.state
+	if from == s {
+		return
+	}
+
+	b.state = s
+	b.failures, b.probes, b.passed = 0, 0, 0
+	if s == StateOpen {
+		b.openedAt = time.Now()
+	}
+
+	if OnStateChange != nil {
+		OnStateChange(b.name, from, s)
+	}
+}
@@ -0,0 +1,52 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := New("test", Config{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Millisecond * 50,
+		HalfOpenRequests: 1,
+	})
+
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatalf("closed breaker rejected request #%d", i)
+		}
+		b.Done(true)
+	}
+	if s := b.State(); s != StateOpen {
+		t.Fatalf("state = %s, want open", s)
+	}
+	if b.Ready() || b.Allow() {
+		t.Fatal("open breaker allowed request")
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	if !b.Allow() {
+		t.Fatal("breaker rejected probe after open timeout")
+	}
+	if s := b.State(); s != StateHalfOpen {
+		t.Fatalf("state = %s, want half-open", s)
+	}
+	if b.Allow() {
+		t.Fatal("half-open breaker allowed more probes than configured")
+	}
+	b.Done(false)
+	if s := b.State(); s != StateClosed {
+		t.Fatalf("state = %s, want closed", s)
+	}
+}
+
+func TestDisabledBreaker(t *testing.T) {
+	b := New("test", Config{})
+	for i := 0; i < 100; i++ {
+		if !b.Allow() {
+			t.Fatalf("disabled breaker rejected request #%d", i)
+		}
+		b.Done(true)
+	}
+}
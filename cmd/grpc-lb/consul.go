@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/liuxp0827/grpc-lb/registry/consul"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type consulStore struct {
+	dc, addr string
+	client   *api.Client
+}
+
+func newConsulStore(addr, dc string) (*consulStore, error) {
+	client, err := api.NewClient(&api.Config{
+		Datacenter: dc,
+		Address:    addr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &consulStore{dc: dc, addr: addr, client: client}, nil
+}
+
+// services returns the names of the consul services of env/name, env or all
+func (s *consulStore) services(service string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	if strings.Contains(service, "/") {
+		return []string{service}, nil, nil
+	}
+
+	all, qm, err := s.client.Catalog().Services(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	for svc := range all {
+		if svc == "consul" || (service != "" && !strings.HasPrefix(svc, service+"/")) {
+			continue
+		}
+		names = append(names, svc)
+	}
+	sort.Strings(names)
+	return names, qm, nil
+}
+
+func (s *consulStore) Apps(service string) ([]instance, error) {
+	names, _, err := s.services(service, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var insts []instance
+	for _, svc := range names {
+		entries, _, err := s.client.Health().Service(svc, "", false, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			insts = append(insts, entry2Instance(entry))
+		}
+	}
+	return insts, nil
+}
+
+func entry2Instance(entry *api.ServiceEntry) instance {
+	a := app.App{
+		Addr:     entry.Service.Address,
+		Port:     entry.Service.Port,
+		Metadata: app.Metadata(entry.Service.Meta),
+	}
+	a.Env, a.Name = splitService(entry.Service.Service)
+	if a.Addr == "" {
+		a.Addr = entry.Node.Address
+	}
+	inst := instance{Health: entry.Checks.AggregatedStatus()}
+	for _, tag := range entry.Service.Tags {
+		switch tag {
+		case app.StatusDraining:
+			a.Status = tag
+		case mirrorTag:
+			inst.Mirrored = true
+		}
+	}
+	inst.App = a
+	return inst
+}
+
+// Watch blocks on the health of the service, or on the catalog for several
+// services, and lists the instances again on every change
+func (s *consulStore) Watch(ctx context.Context, service string, changed func([]instance)) error {
+	q := (&api.QueryOptions{WaitTime: time.Second * 10}).WithContext(ctx)
+	for {
+		var (
+			qm  *api.QueryMeta
+			err error
+		)
+		if strings.Contains(service, "/") {
+			_, qm, err = s.client.Health().Service(service, "", false, q)
+		} else {
+			_, qm, err = s.services(service, q)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		if qm.LastIndex == q.WaitIndex {
+			continue
+		}
+		q.WaitIndex = qm.LastIndex
+
+		insts, err := s.Apps(service)
+		if err != nil {
+			return err
+		}
+		changed(insts)
+	}
+}
+
+func (s *consulStore) Registry(ttl time.Duration) (registry.Registry, error) {
+	return consul.New(s.dc, s.addr, consul.WithChecks(func(app.App) []*api.AgentServiceCheck {
+		return []*api.AgentServiceCheck{consul.TTLCheck(ttl)}
+	}))
+}
+
+func (s *consulStore) Put(a app.App) error {
+	return s.client.Agent().ServiceRegister(consul.Registration(a, nil))
+}
+
+func (s *consulStore) Delete(a app.App) error {
+	return s.client.Agent().ServiceDeregister(consul.ServiceID(a))
+}
+
+// Update registers the service again with its current checks. The agent
+// does not expose the ttl of the ttl checks, they are left out and kept by
+// the agent, which is verified afterwards. The service must have been
+// registered with this agent.
+func (s *consulStore) Update(a app.App) error {
+	id := consul.ServiceID(a)
+	if _, _, err := s.client.Agent().Service(id, nil); err != nil {
+		return err
+	}
+	before, err := s.checks(id)
+	if err != nil {
+		return err
+	}
+
+	// http和tcp check可以按定义重新注册，并保持当前的状态
+	var checks []*api.AgentServiceCheck
+	for _, c := range before {
+		d := c.Definition
+		check := &api.AgentServiceCheck{
+			CheckID:       c.CheckID,
+			Name:          c.Name,
+			Notes:         c.Notes,
+			Status:        c.Status,
+			HTTP:          d.HTTP,
+			Header:        d.Header,
+			Method:        d.Method,
+			TLSSkipVerify: d.TLSSkipVerify,
+			TCP:           d.TCP,
+			Interval:      d.Interval.String(),
+			Timeout:       d.Timeout.String(),
+		}
+		if d.DeregisterCriticalServiceAfter > 0 {
+			check.DeregisterCriticalServiceAfter = d.DeregisterCriticalServiceAfter.String()
+		}
+		if c.Type == "http" && d.HTTP != "" || c.Type == "tcp" && d.TCP != "" {
+			checks = append(checks, check)
+		}
+	}
+	if err := s.client.Agent().ServiceRegister(consul.Registration(a, checks)); err != nil {
+		return err
+	}
+
+	after, err := s.checks(id)
+	if err != nil {
+		return err
+	}
+	var dropped []string
+	for cid := range before {
+		if _, ok := after[cid]; !ok {
+			dropped = append(dropped, cid)
+		}
+	}
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		return fmt.Errorf("the agent dropped the checks %s of %s, the instance has to register again", strings.Join(dropped, ","), id)
+	}
+	return nil
+}
+
+// checks returns the checks of the service registered with the agent
+func (s *consulStore) checks(id string) (map[string]*api.AgentCheck, error) {
+	all, err := s.client.Agent().Checks()
+	if err != nil {
+		return nil, err
+	}
+	checks := make(map[string]*api.AgentCheck)
+	for cid, c := range all {
+		if c.ServiceID == id {
+			checks[cid] = c
+		}
+	}
+	return checks, nil
+}
+
+func (s *consulStore) Close() error {
+	return nil
+}
+
+// mirrorTag marks the services registered by the mirror command
+const mirrorTag = "grpc-lb-mirror"
+
+var metaKey = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+
+// consulSink registers the mirrored apps with a ttl check kept passing until Close
+type consulSink struct {
+	s    *consulStore
+	ttl  time.Duration
+	mu   sync.Mutex
+	ids  map[string]bool
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (s *consulStore) Sink(ttl time.Duration) (sink, error) {
+	k := &consulSink{s: s, ttl: ttl, ids: make(map[string]bool), done: make(chan struct{})}
+	k.wg.Add(1)
+	go k.renew()
+	return k, nil
+}
+
+// Err never receives, the checks of the services are registered again by Put
+func (s *consulSink) Err() <-chan error {
+	return nil
+}
+
+func (s *consulSink) renew() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		// 续约期间不持有锁，避免阻塞Put和Delete
+		for _, id := range s.registered() {
+			if err := s.s.client.Agent().UpdateTTL(id, "", api.HealthPassing); err != nil {
+				fmt.Fprintf(os.Stderr, "grpc-lb: failed to renew %s: %s\n", id, err)
+			}
+		}
+	}
+}
+
+// registered returns the ids of the services registered by the sink
+func (s *consulSink) registered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.ids))
+	for id := range s.ids {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// local returns the service of the agent, nil if not registered
+func (s *consulSink) local(id string) (*api.AgentService, error) {
+	svcs, err := s.s.client.Agent().Services()
+	if err != nil {
+		return nil, err
+	}
+	return svcs[id], nil
+}
+
+func (s *consulSink) Put(a app.App) error {
+	// consul的meta有格式限制，不能原样写入的报错而不是丢弃
+	if len(a.Metadata) > 64 {
+		return fmt.Errorf("%d metadata keys, consul allows 64", len(a.Metadata))
+	}
+	for k, v := range a.Metadata {
+		if !metaKey.MatchString(k) || strings.HasPrefix(k, "consul-") || len(v) > 512 {
+			return fmt.Errorf("metadata %s=%s not allowed by consul", k, v)
+		}
+	}
+
+	id := consul.ServiceID(a)
+	svc, err := s.local(id)
+	if err != nil {
+		return err
+	}
+	if svc != nil && !hasTag(svc.Tags, mirrorTag) {
+		// 实例自己注册后不再续约，避免使它的ttl check一直通过
+		s.mu.Lock()
+		delete(s.ids, id)
+		s.mu.Unlock()
+		return errNotMirrored
+	}
+
+	reg := consul.Registration(a, []*api.AgentServiceCheck{{
+		TTL:                            s.ttl.String(),
+		Status:                         api.HealthPassing,
+		DeregisterCriticalServiceAfter: "1m",
+	}})
+	reg.Tags = append(reg.Tags, mirrorTag)
+	if err := s.s.client.Agent().ServiceRegister(reg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.ids[id] = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *consulSink) Delete(a app.App) error {
+	id := consul.ServiceID(a)
+	s.mu.Lock()
+	delete(s.ids, id)
+	s.mu.Unlock()
+	return s.deregister(id)
+}
+
+// deregister removes the service if it is still the one registered by the mirror
+func (s *consulSink) deregister(id string) error {
+	svc, err := s.local(id)
+	if err != nil || svc == nil || !hasTag(svc.Tags, mirrorTag) {
+		return err
+	}
+	return s.s.client.Agent().ServiceDeregister(id)
+}
+
+func (s *consulSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	var err error
+	for _, id := range s.registered() {
+		if e := s.deregister(id); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
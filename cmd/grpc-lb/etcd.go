@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/liuxp0827/grpc-lb/registry/etcdv3"
+	"go.etcd.io/etcd/clientv3"
+	"path"
+	"strings"
+	"time"
+)
+
+type etcdStore struct {
+	cfg    clientv3.Config
+	client *clientv3.Client
+	prefix string
+}
+
+func newEtcdStore(endpoints, prefix string) (*etcdStore, error) {
+	cfg := clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: time.Second * 5,
+	}
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdStore{cfg: cfg, client: client, prefix: prefix}, nil
+}
+
+func (s *etcdStore) key(service string) string {
+	// 带上结尾的/，避免dev/echo匹配到dev/echo2
+	return path.Join(s.prefix, service) + "/"
+}
+
+func (s *etcdStore) Apps(service string) ([]instance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, s.key(service), clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	var insts []instance
+	for _, kv := range resp.Kvs {
+		// prefix/env/name/addr:port，其它的key(如_config)跳过
+		parts := strings.Split(strings.TrimPrefix(string(kv.Key), s.prefix+"/"), "/")
+		if len(parts) != 3 || strings.HasPrefix(parts[2], "_") {
+			continue
+		}
+		// mirror写入的值带有mirrored标记
+		inst := instance{}
+		json.Unmarshal(kv.Value, &inst)
+		insts = append(insts, inst)
+	}
+	return insts, nil
+}
+
+func (s *etcdStore) Watch(ctx context.Context, service string, changed func([]instance)) error {
+	insts, err := s.Apps(service)
+	if err != nil {
+		return err
+	}
+	changed(insts)
+
+	for resp := range s.client.Watch(ctx, s.key(service), clientv3.WithPrefix()) {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		insts, err := s.Apps(service)
+		if err != nil {
+			return err
+		}
+		changed(insts)
+	}
+	return ctx.Err()
+}
+
+func (s *etcdStore) Registry(ttl time.Duration) (registry.Registry, error) {
+	return etcdv3.New(s.cfg, etcdv3.WithPrefix(s.prefix), etcdv3.WithTTL(int64(ttl/time.Second)))
+}
+
+func (s *etcdStore) Put(a app.App) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, err := s.client.Put(ctx, etcdv3.Key(s.prefix, a), a.Encode())
+	return err
+}
+
+func (s *etcdStore) Delete(a app.App) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	resp, err := s.client.Delete(ctx, etcdv3.Key(s.prefix, a))
+	if err == nil && resp.Deleted == 0 {
+		return registry.ErrNotRegistered
+	}
+	return err
+}
+
+func (s *etcdStore) Update(a app.App) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, err := s.client.Put(ctx, etcdv3.Key(s.prefix, a), a.Encode(), clientv3.WithIgnoreLease())
+	return err
+}
+
+func (s *etcdStore) Close() error {
+	return s.client.Close()
+}
+
+// etcdSink puts the mirrored apps under a single lease, revoked on Close
+type etcdSink struct {
+	s      *etcdStore
+	lease  clientv3.LeaseID
+	cancel context.CancelFunc
+	lost   chan error
+}
+
+func (s *etcdStore) Sink(ttl time.Duration) (sink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	resp, err := s.client.Grant(ctx, int64(ttl/time.Second))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	kctx, kcancel := context.WithCancel(context.Background())
+	ch, err := s.client.KeepAlive(kctx, resp.ID)
+	if err != nil {
+		kcancel()
+		return nil, err
+	}
+	k := &etcdSink{s: s, lease: resp.ID, cancel: kcancel, lost: make(chan error, 1)}
+	go func() {
+		for range ch {
+		}
+		// lease过期后的Put都会失败，由mirror退出
+		if kctx.Err() == nil {
+			k.lost <- fmt.Errorf("lease %x of the mirrored apps lost", resp.ID)
+		}
+	}()
+	return k, nil
+}
+
+func (s *etcdSink) Err() <-chan error {
+	return s.lost
+}
+
+func (s *etcdSink) Put(a app.App) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// 带上mirrored标记，resolver解码app.App时忽略
+	val, err := json.Marshal(instance{App: a, Mirrored: true})
+	if err != nil {
+		return err
+	}
+	key := etcdv3.Key(s.s.prefix, a)
+	put := clientv3.OpPut(key, string(val), clientv3.WithLease(s.lease))
+	// 不存在或者由mirror写入的key才写，其它的由实例自己注册
+	resp, err := s.s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(put).
+		Commit()
+	if err != nil || resp.Succeeded {
+		return err
+	}
+	resp, err = s.s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(key), "=", s.lease)).
+		Then(put).
+		Commit()
+	if err != nil || resp.Succeeded {
+		return err
+	}
+
+	// 之前运行的mirror写入的key，由当前的lease接管
+	get, err := s.s.client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	inst := instance{}
+	if len(get.Kvs) == 0 || json.Unmarshal(get.Kvs[0].Value, &inst) != nil || !inst.Mirrored {
+		return errNotMirrored
+	}
+	resp, err = s.s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", get.Kvs[0].ModRevision)).
+		Then(put).
+		Commit()
+	if err == nil && !resp.Succeeded {
+		return errNotMirrored
+	}
+	return err
+}
+
+func (s *etcdSink) Delete(a app.App) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	key := etcdv3.Key(s.s.prefix, a)
+	_, err := s.s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(key), "=", s.lease)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	return err
+}
+
+func (s *etcdSink) Close() error {
+	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, err := s.s.client.Revoke(ctx, s.lease)
+	return err
+}
@@ -0,0 +1,409 @@
+// Command grpc-lb lists, watches and edits the apps registered in etcd or
+// consul, with the same layout as registry/etcdv3 and registry/consul.
+//
+//	grpc-lb -etcd 127.0.0.1:2379 ls dev/echo
+//	grpc-lb -consul 127.0.0.1:8500 patch -weight 50 dev/echo 10.0.0.1:8080
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	etcdAddr   = flag.String("etcd", "", "etcd endpoints, separated by comma")
+	consulAddr = flag.String("consul", "", "consul agent address")
+	prefix     = flag.String("prefix", "/grpc-discovery", "etcd key prefix")
+	dc         = flag.String("dc", "dc1", "consul datacenter")
+)
+
+var commands = map[string]func(s store, args []string) error{
+	"ls":         ls,
+	"get":        get,
+	"watch":      watch,
+	"register":   register,
+	"deregister": deregister,
+	"patch":      patch,
+}
+
+const usage = `usage: grpc-lb (-etcd endpoints [-prefix prefix] | -consul addr [-dc dc]) command [args]
+
+commands:
+  ls [env[/name]]                       list the envs, the services of an env or the instances of a service
+  get env/name[/addr:port]              print the instances as json
+  watch [env[/name]]                    print the instances added, removed or updated
+  register [flags] env/name addr:port   register an instance until interrupted, or until deregister with -persist
+  deregister env/name addr:port         deregister an instance
+  patch [flags] env/name addr:port      update the metadata or the status of an instance
+  mirror [flags] [env[/name]]           copy the instances from etcd to consul or the reverse, requires both
+
+global flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// mirror读写两个注册中心
+	if flag.Arg(0) == "mirror" {
+		if err := mirror(flag.Args()[1:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := newStore()
+	if err != nil {
+		fatal(err)
+	}
+	defer s.Close()
+
+	if err := cmd(s, flag.Args()[1:]); err != nil {
+		s.Close()
+		fatal(err)
+	}
+}
+
+func newStore() (store, error) {
+	switch {
+	case *etcdAddr != "" && *consulAddr != "":
+		return nil, errors.New("only one of -etcd and -consul can be set")
+	case *etcdAddr != "":
+		return newEtcdStore(*etcdAddr, *prefix)
+	case *consulAddr != "":
+		return newConsulStore(*consulAddr, *dc)
+	}
+	return nil, errors.New("-etcd or -consul is required")
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "grpc-lb:", err)
+	os.Exit(1)
+}
+
+func ls(s store, args []string) error {
+	service := optionalArg(args)
+	insts, err := s.Apps(service)
+	if err != nil {
+		return err
+	}
+
+	// 未指定服务时列出env，只指定env时列出服务
+	if !strings.Contains(service, "/") {
+		count := map[string]int{}
+		for _, inst := range insts {
+			key := inst.Env
+			if service != "" {
+				key = inst.Env + "/" + inst.Name
+			}
+			count[key]++
+		}
+		keys := make([]string, 0, len(count))
+		for key := range count {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%d instances\n", key, count[key])
+		}
+		return w.Flush()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDR\tSTATUS\tHEALTH\tMETADATA")
+	for _, inst := range insts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", inst.addr(), status(inst.Status), inst.Health, metadata(inst.Metadata))
+	}
+	return w.Flush()
+}
+
+func get(s store, args []string) error {
+	if len(args) != 1 || !strings.Contains(args[0], "/") {
+		return errors.New("usage: get env/name[/addr:port]")
+	}
+	service, addr := args[0], ""
+	if parts := strings.SplitN(args[0], "/", 3); len(parts) == 3 {
+		service, addr = parts[0]+"/"+parts[1], parts[2]
+	}
+
+	insts, err := s.Apps(service)
+	if err != nil {
+		return err
+	}
+	if addr != "" {
+		inst, err := find(insts, addr)
+		if err != nil {
+			return err
+		}
+		insts = []instance{inst}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(insts)
+}
+
+func watch(s store, args []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		waitSignal()
+		cancel()
+	}()
+
+	var last []instance
+	err := s.Watch(ctx, optionalArg(args), func(insts []instance) {
+		now := time.Now().Format("15:04:05")
+		for _, change := range diff(last, insts) {
+			fmt.Printf("%s %s\n", now, change)
+		}
+		last = insts
+	})
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+// diff returns the instances added (+), removed (-) and updated (~)
+func diff(old, new []instance) []string {
+	olds := map[string]instance{}
+	for _, inst := range old {
+		olds[inst.key()] = inst
+	}
+	var changes []string
+	for _, inst := range new {
+		k := inst.key()
+		prev, ok := olds[k]
+		delete(olds, k)
+		if !ok {
+			changes = append(changes, describe("+", inst))
+		} else if describe("", prev) != describe("", inst) {
+			changes = append(changes, describe("~", inst))
+		}
+	}
+	for _, inst := range old {
+		if _, ok := olds[inst.key()]; ok {
+			changes = append(changes, describe("-", inst))
+		}
+	}
+	return changes
+}
+
+func describe(op string, inst instance) string {
+	return fmt.Sprintf("%s %s status=%s health=%s %s", op, inst.key(), status(inst.Status), inst.Health, metadata(inst.Metadata))
+}
+
+func register(s store, args []string) error {
+	fs := flag.NewFlagSet("register", flag.ExitOnError)
+	persist := fs.Bool("persist", false, "keep the instance registered after exiting, until deregister")
+	ttl := fs.Duration("ttl", time.Second*10, "ttl of the lease or of the consul check")
+	weight := fs.Int("weight", 0, "weight of the instance")
+	st := fs.String("status", "up", "status of the instance, up or draining")
+	md := metadataFlag{}
+	fs.Var(md, "md", "metadata key=value, can be repeated")
+	fs.Parse(args)
+
+	a, err := parseApp(fs.Args())
+	if err != nil {
+		return fmt.Errorf("%s\nusage: register [flags] env/name addr:port", err)
+	}
+	a.Metadata = md.apply(nil)
+	if *weight > 0 {
+		a.Metadata["weight"] = strconv.Itoa(*weight)
+	}
+	if a.Status, err = parseStatus(*st); err != nil {
+		return err
+	}
+
+	if *persist {
+		return s.Put(a)
+	}
+
+	r, err := s.Registry(*ttl)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	errCh := r.Register(a)
+	fmt.Printf("registered %s/%s %s:%d, interrupt to deregister\n", a.Env, a.Name, a.Addr, a.Port)
+	sig := make(chan struct{})
+	go func() {
+		waitSignal()
+		close(sig)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-sig:
+		return nil
+	}
+}
+
+func deregister(s store, args []string) error {
+	a, err := parseApp(args)
+	if err != nil {
+		return fmt.Errorf("%s\nusage: deregister env/name addr:port", err)
+	}
+	return s.Delete(a)
+}
+
+func patch(s store, args []string) error {
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	weight := fs.Int("weight", 0, "weight of the instance, unchanged if 0")
+	st := fs.String("status", "", "status of the instance, up or draining, unchanged if empty")
+	md := metadataFlag{}
+	fs.Var(md, "md", "metadata key=value, an empty value deletes the key, can be repeated")
+	fs.Parse(args)
+
+	a, err := parseApp(fs.Args())
+	if err != nil {
+		return fmt.Errorf("%s\nusage: patch [flags] env/name addr:port", err)
+	}
+	insts, err := s.Apps(a.Env + "/" + a.Name)
+	if err != nil {
+		return err
+	}
+	inst, err := find(insts, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	a = inst.App
+	a.Metadata = md.apply(a.Metadata)
+	if *weight > 0 {
+		a.Metadata["weight"] = strconv.Itoa(*weight)
+	}
+	if *st != "" {
+		if a.Status, err = parseStatus(*st); err != nil {
+			return err
+		}
+	}
+	return s.Update(a)
+}
+
+// metadataFlag collects the repeated -md key=value flags
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	return metadata(app.Metadata(m))
+}
+
+func (m metadataFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("metadata %q is not key=value", v)
+	}
+	m[v[:i]] = v[i+1:]
+	return nil
+}
+
+// apply returns a copy of md with the flags set, the keys with empty values removed
+func (m metadataFlag) apply(md app.Metadata) app.Metadata {
+	out := app.Metadata{}
+	for k, v := range md {
+		out[k] = v
+	}
+	for k, v := range m {
+		if v == "" {
+			delete(out, k)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func parseApp(args []string) (app.App, error) {
+	if len(args) != 2 {
+		return app.App{}, errors.New("env/name and addr:port are required")
+	}
+	env, name := splitService(args[0])
+	host, port, err := net.SplitHostPort(args[1])
+	if err != nil {
+		return app.App{}, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return app.App{}, fmt.Errorf("invalid port %q", port)
+	}
+	return app.App{Env: env, Name: name, Addr: host, Port: p}, nil
+}
+
+func parseStatus(s string) (string, error) {
+	switch s {
+	case "up":
+		return app.StatusUp, nil
+	case app.StatusDraining:
+		return app.StatusDraining, nil
+	}
+	return "", fmt.Errorf("invalid status %q, must be up or draining", s)
+}
+
+func find(insts []instance, addr string) (instance, error) {
+	for _, inst := range insts {
+		if inst.addr() == addr {
+			return inst, nil
+		}
+	}
+	return instance{}, registry.ErrNotRegistered
+}
+
+func optionalArg(args []string) string {
+	if len(args) > 0 {
+		return strings.Trim(args[0], "/")
+	}
+	return ""
+}
+
+func status(s string) string {
+	if s == app.StatusUp {
+		return "up"
+	}
+	return s
+}
+
+func metadata(md app.Metadata) string {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, k+"="+md[k])
+	}
+	return strings.Join(kvs, ",")
+}
+
+func waitSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/liuxp0827/grpc-lb/app"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := instance{App: app.App{Env: "dev", Name: "echo", Addr: "10.0.0.1", Port: 80, Metadata: app.Metadata{"weight": "10"}}}
+	b := instance{App: app.App{Env: "dev", Name: "echo", Addr: "10.0.0.2", Port: 80}}
+	c := instance{App: app.App{Env: "dev", Name: "echo", Addr: "10.0.0.3", Port: 80}}
+	a2 := a
+	a2.Status = app.StatusDraining
+
+	got := diff([]instance{a, b}, []instance{a2, c})
+	want := []string{
+		"~ dev/echo 10.0.0.1:80 status=draining health= weight=10",
+		"+ dev/echo 10.0.0.3:80 status=up health= ",
+		"- dev/echo 10.0.0.2:80 status=up health= ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+}
+
+func TestMetadataFlag(t *testing.T) {
+	md := metadataFlag{}
+	for _, v := range []string{"weight=50", "zone=", "idc=a=b"} {
+		if err := md.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := md.Set("=x"); err == nil {
+		t.Error("expected an error for =x")
+	}
+
+	old := app.Metadata{"weight": "10", "zone": "sh"}
+	got := md.apply(old)
+	want := app.Metadata{"weight": "50", "idc": "a=b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("apply = %v, want %v", got, want)
+	}
+	if old["weight"] != "10" {
+		t.Error("apply modified the metadata")
+	}
+}
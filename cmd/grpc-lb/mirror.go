@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"github.com/liuxp0827/grpc-lb/app"
+	"sync"
+	"time"
+)
+
+var errNotMirrored = errors.New("registered by another process")
+
+// sink writes the apps mirrored from another registry
+type sink interface {
+	// Put writes the app, errNotMirrored if it is registered by another process
+	Put(a app.App) error
+	// Delete removes the app if it is still the one written by the sink
+	Delete(a app.App) error
+	// Err receives the error after which the sink can no longer write
+	Err() <-chan error
+	// Close removes all the apps written by the sink
+	Close() error
+}
+
+func mirror(args []string) error {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	from := fs.String("from", "etcd", "registry to mirror from, etcd or consul")
+	dryRun := fs.Bool("dry-run", false, "print the changes without writing them")
+	ttl := fs.Duration("ttl", time.Second*10, "ttl of the mirrored instances, they expire if the mirror stops")
+	fs.Parse(args)
+
+	if *etcdAddr == "" || *consulAddr == "" {
+		return errors.New("mirror requires both -etcd and -consul")
+	}
+	if *ttl < time.Second {
+		return errors.New("ttl must be at least 1s")
+	}
+	etcd, err := newEtcdStore(*etcdAddr, *prefix)
+	if err != nil {
+		return err
+	}
+	defer etcd.Close()
+	consul, err := newConsulStore(*consulAddr, *dc)
+	if err != nil {
+		return err
+	}
+	defer consul.Close()
+
+	var src, dst store
+	switch *from {
+	case "etcd":
+		src, dst = etcd, consul
+	case "consul":
+		src, dst = consul, etcd
+	default:
+		return fmt.Errorf("invalid -from %q, must be etcd or consul", *from)
+	}
+
+	m := newMirrorer(dst, optionalArg(fs.Args()))
+	if *dryRun {
+		fmt.Println("dry run, nothing is written")
+	} else {
+		if m.sink, err = dst.Sink(*ttl); err != nil {
+			return err
+		}
+		// 退出时注销所有复制的实例
+		defer m.sink.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		waitSignal()
+		cancel()
+	}()
+	// sink无法继续写入时退出，避免复制的实例全部过期后仍在运行
+	sinkErr := make(chan error, 1)
+	if m.sink != nil {
+		go func() {
+			select {
+			case err := <-m.sink.Err():
+				sinkErr <- err
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	// 目标的变化也要重新同步，比如上次运行复制的实例过期
+	dstDone := make(chan struct{})
+	go func() {
+		defer close(dstDone)
+		if err := dst.Watch(ctx, m.service, func([]instance) { m.resync() }); err != nil && err != context.Canceled {
+			m.print("! failed to watch the destination: %s", err)
+		}
+	}()
+
+	err = src.Watch(ctx, m.service, m.sync)
+	// 等待目标的同步结束后再注销复制的实例
+	cancel()
+	<-dstDone
+	if err == context.Canceled {
+		select {
+		case err = <-sinkErr:
+		default:
+			err = nil
+		}
+	}
+	return err
+}
+
+// mirrorer copies the instances of the source to the destination, the
+// instances already registered in the destination by another process are
+// left untouched and reported
+type mirrorer struct {
+	dst     store
+	sink    sink // 为nil时只输出变更
+	service string
+	print   func(format string, args ...interface{})
+
+	mu        sync.Mutex
+	src       []instance // 源的最新实例，目标变化时重新同步
+	mirrored  map[string]app.App
+	conflicts map[string]string
+}
+
+func newMirrorer(dst store, service string) *mirrorer {
+	return &mirrorer{
+		dst:     dst,
+		service: service,
+		print: func(format string, args ...interface{}) {
+			fmt.Printf(time.Now().Format("15:04:05 ")+format+"\n", args...)
+		},
+		mirrored:  make(map[string]app.App),
+		conflicts: make(map[string]string),
+	}
+}
+
+// sync copies the instances of the source
+func (m *mirrorer) sync(insts []instance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.src = insts
+	m.apply()
+}
+
+// resync copies the last instances of the source again, after the
+// destination changed, e.g. the apps mirrored by a previous run expired
+func (m *mirrorer) resync() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.src != nil {
+		m.apply()
+	}
+}
+
+// apply must be called with m.mu held
+func (m *mirrorer) apply() {
+	current, err := m.dst.Apps(m.service)
+	if err != nil {
+		m.print("! failed to list the destination: %s", err)
+		return
+	}
+	dsts := make(map[string]instance, len(current))
+	for _, inst := range current {
+		// 同时存在复制的和其它进程注册的实例时，以其它进程注册的为准
+		if d, ok := dsts[inst.key()]; !ok || d.Mirrored {
+			dsts[inst.key()] = inst
+		}
+	}
+
+	want := make(map[string]bool, len(m.src))
+	for _, inst := range m.src {
+		// 跳过不健康的实例，以及反向mirror写入的实例，避免循环复制
+		if inst.Mirrored || inst.Health == api.HealthCritical {
+			continue
+		}
+		k := inst.key()
+		want[k] = true
+
+		prev, ok := m.mirrored[k]
+		d, exists := dsts[k]
+		if exists && !d.Mirrored {
+			// 实例已经自己注册到目标，删除复制的实例
+			if ok {
+				if err := m.delete(prev); err != nil {
+					m.report(k, fmt.Sprintf("! %s: %s", k, err))
+					continue
+				}
+				delete(m.mirrored, k)
+			}
+			m.conflict(k, inst, d)
+			continue
+		}
+		// 复制的实例在目标中消失(比如过期)时重新写入
+		missing := !exists && m.sink != nil
+		if ok && !missing && same(prev, inst.App) {
+			continue
+		}
+
+		op := "+"
+		if ok && !missing {
+			op = "~"
+		}
+		if err := m.put(inst.App); err == errNotMirrored {
+			delete(m.mirrored, k)
+			m.conflict(k, inst, dsts[k])
+			continue
+		} else if err != nil {
+			m.report(k, fmt.Sprintf("! %s: %s", k, err))
+			continue
+		}
+		delete(m.conflicts, k)
+		m.mirrored[k] = inst.App
+		m.print("%s", describe(op, inst))
+	}
+
+	for k, a := range m.mirrored {
+		if want[k] {
+			continue
+		}
+		if err := m.delete(a); err != nil {
+			m.print("! %s: %s", k, err)
+			continue
+		}
+		delete(m.mirrored, k)
+		m.print("%s", describe("-", instance{App: a}))
+	}
+	for k := range m.conflicts {
+		if !want[k] {
+			delete(m.conflicts, k)
+		}
+	}
+}
+
+// conflict reports once that the instance is registered in the destination
+// by another process, not an error if both are the same
+func (m *mirrorer) conflict(k string, src, dst instance) {
+	msg := fmt.Sprintf("= %s already registered in the destination", k)
+	if !same(src.App, dst.App) {
+		msg = fmt.Sprintf("! %s conflict, registered in the destination by another process: source status=%s %s, destination status=%s %s",
+			k, status(src.Status), metadata(src.Metadata), status(dst.Status), metadata(dst.Metadata))
+	}
+	m.report(k, msg)
+}
+
+// report prints the message unless it is the last one of the instance
+func (m *mirrorer) report(k, msg string) {
+	if m.conflicts[k] != msg {
+		m.conflicts[k] = msg
+		m.print("%s", msg)
+	}
+}
+
+func (m *mirrorer) put(a app.App) error {
+	if m.sink == nil {
+		return nil
+	}
+	return m.sink.Put(a)
+}
+
+func (m *mirrorer) delete(a app.App) error {
+	if m.sink == nil {
+		return nil
+	}
+	return m.sink.Delete(a)
+}
+
+// same tells whether both apps have the same status and metadata
+func same(a, b app.App) bool {
+	return a.Status == b.Status && metadata(a.Metadata) == metadata(b.Metadata)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"reflect"
+	"testing"
+)
+
+type fakeStore struct {
+	store
+	apps []instance
+	sink fakeSink
+}
+
+// Apps returns the apps registered by other processes and the ones of the sink
+func (s *fakeStore) Apps(string) ([]instance, error) {
+	insts := append([]instance(nil), s.apps...)
+	for _, a := range s.sink {
+		insts = append(insts, instance{App: a, Mirrored: true})
+	}
+	return insts, nil
+}
+
+type fakeSink map[string]app.App
+
+func (s fakeSink) Put(a app.App) error {
+	s[fmt.Sprintf("%s:%d", a.Addr, a.Port)] = a
+	return nil
+}
+
+func (s fakeSink) Delete(a app.App) error {
+	delete(s, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+	return nil
+}
+
+func (s fakeSink) Err() <-chan error {
+	return nil
+}
+
+func (s fakeSink) Close() error {
+	return nil
+}
+
+func TestMirrorSync(t *testing.T) {
+	inst := func(addr string, weight string) instance {
+		return instance{App: app.App{Env: "dev", Name: "echo", Addr: addr, Port: 80, Metadata: app.Metadata{"weight": weight}}}
+	}
+	sk := fakeSink{}
+	dst := &fakeStore{apps: []instance{inst("10.0.0.2", "10"), inst("10.0.0.3", "20")}, sink: sk}
+	m := newMirrorer(dst, "dev/echo")
+	m.sink = sk
+	var out []string
+	m.print = func(format string, args ...interface{}) {
+		out = append(out, fmt.Sprintf(format, args...))
+	}
+
+	critical := inst("10.0.0.4", "10")
+	critical.Health = "critical"
+	src := []instance{inst("10.0.0.1", "10"), inst("10.0.0.2", "10"), inst("10.0.0.3", "10"), critical}
+	m.sync(src)
+	m.sync(src)
+	want := []string{
+		"+ dev/echo 10.0.0.1:80 status=up health= weight=10",
+		"= dev/echo 10.0.0.2:80 already registered in the destination",
+		"! dev/echo 10.0.0.3:80 conflict, registered in the destination by another process: source status=up weight=10, destination status=up weight=20",
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if len(sk) != 1 || sk["10.0.0.1:80"].Metadata["weight"] != "10" {
+		t.Errorf("sink = %v", sk)
+	}
+
+	out = nil
+	m.sync([]instance{inst("10.0.0.2", "10")})
+	want = []string{"- dev/echo 10.0.0.1:80 status=up health= weight=10"}
+	if !reflect.DeepEqual(out, want) || len(sk) != 0 {
+		t.Errorf("output = %q, sink = %v", out, sk)
+	}
+}
+
+func TestMirrorResync(t *testing.T) {
+	a := instance{App: app.App{Env: "dev", Name: "echo", Addr: "10.0.0.1", Port: 80, Metadata: app.Metadata{"weight": "10"}}}
+	sk := fakeSink{}
+	dst := &fakeStore{sink: sk}
+	m := newMirrorer(dst, "dev/echo")
+	m.sink = sk
+	var out []string
+	m.print = func(format string, args ...interface{}) {
+		out = append(out, fmt.Sprintf(format, args...))
+	}
+
+	m.sync([]instance{a})
+	if len(sk) != 1 {
+		t.Fatalf("sink = %v", sk)
+	}
+
+	// 复制的实例过期后，目标的变化触发重新写入
+	delete(sk, "10.0.0.1:80")
+	m.resync()
+	if len(sk) != 1 {
+		t.Errorf("expired instance not written again, sink = %v", sk)
+	}
+
+	// 实例自己注册后删除复制的实例
+	out = nil
+	dst.apps = []instance{a}
+	m.resync()
+	want := []string{"= dev/echo 10.0.0.1:80 already registered in the destination"}
+	if !reflect.DeepEqual(out, want) || len(sk) != 0 {
+		t.Errorf("output = %q, sink = %v", out, sk)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"strings"
+	"time"
+)
+
+// instance is an app found in the registry, Health is the aggregated status
+// of the checks for consul, Mirrored is set for the instances written by the
+// mirror command
+type instance struct {
+	app.App
+	Health   string `json:"health,omitempty"`
+	Mirrored bool   `json:"mirrored,omitempty"`
+}
+
+func (i instance) addr() string {
+	return fmt.Sprintf("%s:%d", i.Addr, i.Port)
+}
+
+// key identifies the instance in both registries, env/name addr:port
+func (i instance) key() string {
+	return i.Env + "/" + i.Name + " " + i.addr()
+}
+
+// store reads and writes the apps with the same layout as the registry and
+// the resolver of its scheme
+type store interface {
+	// Apps returns the instances of the service env/name, of every service of
+	// the env, or all of them if empty
+	Apps(service string) ([]instance, error)
+	// Watch calls changed with the instances every time they change, until ctx is done
+	Watch(ctx context.Context, service string, changed func([]instance)) error
+	// Registry keeps the apps registered while the command runs
+	Registry(ttl time.Duration) (registry.Registry, error)
+	// Put registers the app until Delete, without any lease or check
+	Put(a app.App) error
+	Delete(a app.App) error
+	// Update writes the app again, keeping its lease or its checks
+	Update(a app.App) error
+	// Sink writes the apps mirrored from the other registry, they expire
+	// within ttl once not renewed
+	Sink(ttl time.Duration) (sink, error)
+	Close() error
+}
+
+// splitService splits env/name, a service without env has no slash
+func splitService(service string) (env, name string) {
+	if i := strings.Index(service, "/"); i >= 0 {
+		return service[:i], service[i+1:]
+	}
+	return "", service
+}
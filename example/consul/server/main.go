@@ -6,14 +6,12 @@ import (
 	"fmt"
 	"github.com/liuxp0827/grpc-lb/app"
 	"github.com/liuxp0827/grpc-lb/example/proto"
-	"github.com/liuxp0827/grpc-lb/internal/logger"
 	"github.com/liuxp0827/grpc-lb/registry/consul"
+	"github.com/liuxp0827/grpc-lb/server"
 	"google.golang.org/grpc"
 	"log"
 	"net"
 	"os"
-	"os/signal"
-	"syscall"
 )
 
 var InstanceID = os.Getenv("INSTANCE_ID")
@@ -31,7 +29,7 @@ func main() {
 	port := flag.Int("port", 6060, "port")
 	flag.Parse()
 
-	r, err := consul.New("dc1", "http://127.0.0.1:8500", logger.DefaultLogger)
+	r, err := consul.New("dc1", "http://127.0.0.1:8500")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -44,28 +42,12 @@ func main() {
 	s := grpc.NewServer()
 	proto.RegisterEchoSvcServer(s, &EchoServer{})
 
-	errCh := r.Register(app.App{
+	a := app.App{
 		Env:  "dev",
 		Name: "demo",
-		Addr: "127.0.0.1",
-		Port: *port,
-	})
-
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
-		select {
-		case <-sig:
-			r.Close()
-			s.GracefulStop()
-			os.Exit(0)
-		case err := <-errCh:
-			log.Fatalf("failed to register: %s", err.Error())
-		}
-	}()
-
-	log.Printf("listening port: %d", *port)
-	if err := s.Serve(lis); err != nil {
-		log.Fatal(err)
 	}
+	log.Printf("listening port: %d", *port)
+	// 地址和端口由监听地址、POD_IP等环境变量或网卡地址自动获取
+	// 开始接收连接后注册，收到信号时先注销，等待客户端感知后再停止服务
+	server.New(s, r, a).Run(lis)
 }
@@ -7,15 +7,13 @@ import (
 	"github.com/liuxp0827/grpc-lb/app"
 	"github.com/liuxp0827/grpc-lb/example/proto"
 	"github.com/liuxp0827/grpc-lb/registry/etcdv3"
+	"github.com/liuxp0827/grpc-lb/server"
 	"go.etcd.io/etcd/clientv3"
 	"google.golang.org/grpc"
 	"log"
 	"net"
-	"os"
-	"os/signal"
 	"strconv"
 	"sync/atomic"
-	"syscall"
 	"time"
 )
 
@@ -55,28 +53,12 @@ func main() {
 	s := grpc.NewServer()
 	proto.RegisterEchoSvcServer(s, &EchoServer{})
 
-	errCh := r.Register(app.App{
+	a := app.App{
 		Env:      "dev",
 		Name:     "demo",
-		Addr:     "127.0.0.1",
-		Port:     *port,
 		Metadata: app.Metadata{"weight": strconv.Itoa(*weight)},
-	})
-
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
-		select {
-		case <-sig:
-			r.Close()
-			s.GracefulStop()
-			os.Exit(0)
-		case err := <-errCh:
-			log.Fatalf("failed to register: %s", err.Error())
-		}
-	}()
-
-	if err := s.Serve(lis); err != nil {
-		log.Fatal(err)
 	}
+	// 地址和端口由监听地址、POD_IP等环境变量或网卡地址自动获取
+	// 开始接收连接后注册，收到信号时先注销，等待客户端感知后再停止服务
+	server.New(s, r, a).Run(lis)
 }
@@ -0,0 +1,305 @@
+package admin
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"google.golang.org/grpc/resolver"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registration is an app registered by one of the registries of the process
+type Registration struct {
+	Registry     string            `json:"registry"`
+	Service      string            `json:"service"`
+	Addr         string            `json:"addr"`
+	Status       string            `json:"status"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	RegisteredAt time.Time         `json:"registered_at"`
+	LastRenew    time.Time         `json:"last_renew"`
+	// RenewFailures are the consecutive failed renewals, LastError the last one
+	RenewFailures int    `json:"renew_failures"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+type Address struct {
+	Addr       string            `json:"addr"`
+	ServerName string            `json:"server_name,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Target is the state last pushed by the resolver of a ClientConn
+type Target struct {
+	Scheme     string    `json:"scheme"`
+	Target     string    `json:"target"`
+	Addresses  []Address `json:"addresses"`
+	Updates    int       `json:"updates"`
+	LastUpdate time.Time `json:"last_update"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// Peer is an address of the current picker of a balancer, Breaker is the
+// state of its circuit breaker, an open one ejects the peer.
+type Peer struct {
+	Addr          string                 `json:"addr"`
+	Weight        float64                `json:"weight"`
+	CurrentWeight float64                `json:"current_weight"`
+	Breaker       string                 `json:"breaker,omitempty"`
+	Details       map[string]interface{} `json:"details,omitempty"`
+}
+
+type Balancer struct {
+	Balancer string `json:"balancer"`
+	Target   string `json:"target"`
+	Peers    []Peer `json:"peers"`
+}
+
+type Snapshot struct {
+	Registrations []Registration `json:"registrations"`
+	Resolvers     []Target       `json:"resolvers"`
+	Balancers     []Balancer     `json:"balancers"`
+}
+
+type balancerState struct {
+	balancer, target string
+	peers            func() []Peer
+}
+
+var (
+	mu            sync.Mutex
+	registrations = make(map[string]*Registration) // registry/env/name/addr -> registration
+	targets       = make(map[*clientConn]struct{})
+	balancers     = make(map[*balancerState]struct{})
+)
+
+func registrationKey(registry string, a app.App) string {
+	return registry + "/" + a.Env + "/" + a.Name + "/" + addrOf(a)
+}
+
+// Registered reports the app as registered, until the returned func is called
+func Registered(registry string, a app.App) func() {
+	key := registrationKey(registry, a)
+	reg := &Registration{
+		Registry:     registry,
+		Service:      a.Env + "/" + a.Name,
+		Addr:         addrOf(a),
+		Status:       status(a),
+		Metadata:     copyMetadata(a.Metadata),
+		RegisteredAt: time.Now(),
+	}
+
+	mu.Lock()
+	registrations[key] = reg
+	mu.Unlock()
+
+	return func() {
+		mu.Lock()
+		// 同一个key可能已被之后的注册替换
+		if registrations[key] == reg {
+			delete(registrations, key)
+		}
+		mu.Unlock()
+	}
+}
+
+func Renewed(registry string, a app.App, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	reg, ok := registrations[registrationKey(registry, a)]
+	if !ok {
+		return
+	}
+	if err != nil {
+		reg.RenewFailures++
+		reg.LastError = err.Error()
+		return
+	}
+	reg.LastRenew, reg.RenewFailures = time.Now(), 0
+}
+
+// Updated reports the status and the metadata the app was updated with
+func Updated(registry string, a app.App) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if reg, ok := registrations[registrationKey(registry, a)]; ok {
+		reg.Status, reg.Metadata = status(a), copyMetadata(a.Metadata)
+	}
+}
+
+// Builder reports the states pushed by the resolvers of b until they are closed
+func Builder(b resolver.Builder) resolver.Builder {
+	return &builder{Builder: b}
+}
+
+type builder struct {
+	resolver.Builder
+}
+
+// Unwrap returns the wrapped builder, the resolvers built as a part of
+// another one use it not to be counted twice
+func (b *builder) Unwrap() resolver.Builder {
+	return b.Builder
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	wrapped := &clientConn{
+		ClientConn: cc,
+		target:     Target{Scheme: b.Scheme(), Target: target.Endpoint},
+	}
+
+	mu.Lock()
+	targets[wrapped] = struct{}{}
+	mu.Unlock()
+
+	r, err := b.Builder.Build(target, wrapped, opts)
+	if err != nil {
+		wrapped.remove()
+		return nil, err
+	}
+	return &closer{Resolver: r, cc: wrapped}, nil
+}
+
+type closer struct {
+	resolver.Resolver
+	cc *clientConn
+}
+
+func (c *closer) Close() {
+	c.Resolver.Close()
+	c.cc.remove()
+}
+
+type clientConn struct {
+	resolver.ClientConn
+	target Target // guarded by mu
+}
+
+func (cc *clientConn) remove() {
+	mu.Lock()
+	delete(targets, cc)
+	mu.Unlock()
+}
+
+func (cc *clientConn) UpdateState(s resolver.State) {
+	cc.update(s.Addresses)
+	cc.ClientConn.UpdateState(s)
+}
+
+func (cc *clientConn) NewAddress(addrs []resolver.Address) {
+	cc.update(addrs)
+	cc.ClientConn.NewAddress(addrs)
+}
+
+func (cc *clientConn) ReportError(err error) {
+	mu.Lock()
+	cc.target.LastError = err.Error()
+	mu.Unlock()
+	cc.ClientConn.ReportError(err)
+}
+
+func (cc *clientConn) update(addrs []resolver.Address) {
+	addresses := make([]Address, 0, len(addrs))
+	for _, addr := range addrs {
+		addresses = append(addresses, Address{
+			Addr:       addr.Addr,
+			ServerName: addr.ServerName,
+			Metadata:   metadata(addr.Metadata),
+		})
+	}
+
+	mu.Lock()
+	cc.target.Addresses = addresses
+	cc.target.Updates++
+	cc.target.LastUpdate = time.Now()
+	cc.target.LastError = ""
+	mu.Unlock()
+}
+
+// Balanced reports the peers of the balancer of the target, until the
+// returned func is called. peers is called on every snapshot.
+func Balanced(balancer, target string, peers func() []Peer) func() {
+	b := &balancerState{balancer: balancer, target: target, peers: peers}
+
+	mu.Lock()
+	balancers[b] = struct{}{}
+	mu.Unlock()
+
+	return func() {
+		mu.Lock()
+		delete(balancers, b)
+		mu.Unlock()
+	}
+}
+
+func State() Snapshot {
+	mu.Lock()
+	s := Snapshot{
+		Registrations: make([]Registration, 0, len(registrations)),
+		Resolvers:     make([]Target, 0, len(targets)),
+		Balancers:     make([]Balancer, 0, len(balancers)),
+	}
+	for _, reg := range registrations {
+		s.Registrations = append(s.Registrations, *reg)
+	}
+	for cc := range targets {
+		s.Resolvers = append(s.Resolvers, cc.target)
+	}
+	states := make([]*balancerState, 0, len(balancers))
+	for b := range balancers {
+		states = append(states, b)
+	}
+	mu.Unlock()
+
+	// 在锁外获取picker的状态，避免与picker的锁互相等待
+	for _, b := range states {
+		s.Balancers = append(s.Balancers, Balancer{Balancer: b.balancer, Target: b.target, Peers: b.peers()})
+	}
+
+	sort.Slice(s.Registrations, func(i, j int) bool {
+		a, b := s.Registrations[i], s.Registrations[j]
+		return a.Registry+a.Service+a.Addr < b.Registry+b.Service+b.Addr
+	})
+	sort.Slice(s.Resolvers, func(i, j int) bool {
+		return s.Resolvers[i].Scheme+s.Resolvers[i].Target < s.Resolvers[j].Scheme+s.Resolvers[j].Target
+	})
+	sort.Slice(s.Balancers, func(i, j int) bool {
+		return s.Balancers[i].Balancer+s.Balancers[i].Target < s.Balancers[j].Balancer+s.Balancers[j].Target
+	})
+	return s
+}
+
+func addrOf(a app.App) string {
+	return fmt.Sprintf("%s:%d", a.Addr, a.Port)
+}
+
+func status(a app.App) string {
+	if a.Status == app.StatusUp {
+		return "up"
+	}
+	return a.Status
+}
+
+func copyMetadata(md map[string]string) map[string]string {
+	if len(md) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(md))
+	for k, v := range md {
+		c[k] = v
+	}
+	return c
+}
+
+// metadata copies the metadata of an address, the value of the unknown
+// types is shown as is.
+func metadata(md interface{}) map[string]string {
+	m, ok := internalresolver.Metadata(md)
+	if !ok {
+		return map[string]string{"": fmt.Sprint(md)}
+	}
+	return copyMetadata(m)
+}
@@ -0,0 +1,267 @@
+package load_aware
+
+import (
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/balancer/smooth_weighted"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/loadreport"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"go.opentelemetry.io/otel/attribute"
+	bl "google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"sync"
+	"time"
+)
+
+const (
+	Name          = "load_aware_lb"
+	defaultWeight = 100
+	minIdle       = 0.01
+)
+
+var (
+	// Blend is the share of the weight driven by the reported load,
+	// the rest is the weight registered in the metadata.
+	Blend = 0.8
+	// ReportTTL is how long a load report stays valid, instances without
+	// a valid report fall back to their registered weight.
+	ReportTTL = time.Second * 30
+	// Logger logs the load reports received at debug level, logger.Default if nil
+	Logger logger.Logger
+)
+
+func init() {
+	bl.Register(&loadAwareBuilder{})
+}
+
+type loadAwareBuilder struct{}
+
+// Build creates a base balancer per ClientConn, so that the load reports of
+// one target never leak into the picker of another.
+func (*loadAwareBuilder) Build(cc bl.ClientConn, opts bl.BuildOptions) bl.Balancer {
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+	pb := &loadAwarePickerBuilder{
+		loads:  make(map[string]*load),
+		logger: logger.With(l, "balancer", Name, "target", opts.Target.Endpoint),
+	}
+	b := base.NewBalancerBuilderV2(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+	return &loadAwareBalancer{
+		Balancer:   b,
+		v2:         b.(bl.V2Balancer),
+		unregister: admin.Balanced(Name, opts.Target.Endpoint, pb.peers),
+	}
+}
+
+func (*loadAwareBuilder) Name() string {
+	return Name
+}
+
+// loadAwareBalancer stops reporting the peers to the admin handler once closed
+type loadAwareBalancer struct {
+	bl.Balancer
+	v2         bl.V2Balancer
+	unregister func()
+}
+
+func (b *loadAwareBalancer) UpdateClientConnState(s bl.ClientConnState) error {
+	return b.v2.UpdateClientConnState(s)
+}
+
+func (b *loadAwareBalancer) ResolverError(err error) {
+	b.v2.ResolverError(err)
+}
+
+func (b *loadAwareBalancer) UpdateSubConnState(sc bl.SubConn, s bl.SubConnState) {
+	b.v2.UpdateSubConnState(sc, s)
+}
+
+func (b *loadAwareBalancer) Close() {
+	b.unregister()
+	b.Balancer.Close()
+}
+
+type loadAwarePickerBuilder struct {
+	mu     sync.Mutex
+	loads  map[string]*load // addr -> load, kept across pickers
+	logger logger.Logger
+	picker *loadAwarePicker // the last one built, for the admin handler
+}
+
+func (b *loadAwarePickerBuilder) Build(info base.PickerBuildInfo) bl.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		b.mu.Lock()
+		b.picker = nil
+		b.mu.Unlock()
+		return base.NewErrPickerV2(bl.ErrNoSubConnAvailable)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	loads := make(map[string]*load, len(info.ReadySCs))
+	p := &loadAwarePicker{peers: make([]*peer, 0, len(info.ReadySCs)), logger: b.logger}
+	for sc, info := range info.ReadySCs {
+		l, ok := b.loads[info.Address.Addr]
+		if !ok {
+			l = &load{}
+		}
+		loads[info.Address.Addr] = l
+
+		weight := smooth_weighted.Weight(info.Address)
+		if weight <= 0 {
+			weight = defaultWeight
+		}
+
+		p.peers = append(p.peers, &peer{
+			subConn: sc,
+			addr:    info.Address.Addr,
+			weight:  float64(weight),
+			load:    l,
+		})
+	}
+	b.loads = loads
+	b.picker = p
+
+	return p
+}
+
+// peers returns the state of the peers of the last picker, with the load
+// they reported
+func (b *loadAwarePickerBuilder) peers() []admin.Peer {
+	b.mu.Lock()
+	p := b.picker
+	b.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	peers := make([]admin.Peer, 0, len(p.peers))
+	for _, wp := range p.peers {
+		peer := admin.Peer{
+			Addr:          wp.addr,
+			Weight:        wp.weight,
+			CurrentWeight: wp.currentWeight,
+			Details:       map[string]interface{}{},
+		}
+		if score, ok := wp.load.score(now); ok {
+			wp.load.mu.RLock()
+			peer.Details["score"] = score
+			peer.Details["cpu"] = wp.load.report.CPU
+			peer.Details["inflight"] = wp.load.report.InFlight
+			wp.load.mu.RUnlock()
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+type load struct {
+	mu        sync.RWMutex
+	report    loadreport.Report
+	updatedAt time.Time
+}
+
+func (l *load) update(r loadreport.Report) {
+	l.mu.Lock()
+	l.report, l.updatedAt = r, time.Now()
+	l.mu.Unlock()
+}
+
+// score is the spare capacity of the instance, ok is false if no valid
+// report has been received.
+func (l *load) score(now time.Time) (score float64, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.updatedAt.IsZero() || now.Sub(l.updatedAt) > ReportTTL {
+		return 0, false
+	}
+
+	idle := 1 - l.report.CPU
+	if idle < minIdle {
+		idle = minIdle
+	}
+	return idle / float64(1+l.report.InFlight), true
+}
+
+type peer struct {
+	subConn       bl.SubConn
+	addr          string
+	weight        float64
+	load          *load
+	score         float64
+	currentWeight float64
+}
+
+type loadAwarePicker struct {
+	peers  []*peer
+	mu     sync.Mutex
+	logger logger.Logger
+}
+
+func (p *loadAwarePicker) Pick(info bl.PickInfo) (bl.PickResult, error) {
+	if len(p.peers) == 1 {
+		return p.result(info, p.peers[0]), nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 以有负载上报的实例的平均空闲度为基准，没有上报的实例按注册权重处理
+	now := time.Now()
+	sum, n := 0.0, 0
+	for _, wp := range p.peers {
+		score, ok := wp.load.score(now)
+		if !ok {
+			score = -1
+		} else {
+			sum += score
+			n++
+		}
+		wp.score = score
+	}
+
+	best := -1
+	total := 0.0
+	for i, wp := range p.peers {
+		ratio := 1.0
+		if wp.score >= 0 && sum > 0 {
+			ratio = wp.score * float64(n) / sum
+		}
+		weight := wp.weight * (1 - Blend + Blend*ratio)
+
+		wp.currentWeight += weight
+		total += weight
+		if best == -1 || wp.currentWeight > p.peers[best].currentWeight {
+			best = i
+		}
+	}
+	p.peers[best].currentWeight -= total
+
+	return p.result(info, p.peers[best]), nil
+}
+
+func (p *loadAwarePicker) result(info bl.PickInfo, wp *peer) bl.PickResult {
+	// score为-1表示没有有效的负载上报
+	tracing.Picked(info.Ctx, Name, wp.addr,
+		attribute.Float64("grpc_lb.weight", wp.weight),
+		attribute.Float64("grpc_lb.load_score", wp.score),
+		attribute.Float64("grpc_lb.current_weight", wp.currentWeight),
+	)
+	return bl.PickResult{
+		SubConn: wp.subConn,
+		Done: func(info bl.DoneInfo) {
+			if r, ok := loadreport.FromTrailer(info.Trailer); ok {
+				p.logger.Debug("load report received", "addr", wp.addr, "cpu", r.CPU, "inflight", r.InFlight)
+				wp.load.update(r)
+			}
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package load_aware
+
+import (
+	"context"
+	"github.com/liuxp0827/grpc-lb/loadreport"
+	"github.com/liuxp0827/grpc-lb/logger"
+	bl "google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"testing"
+	"time"
+)
+
+type testSubConn struct {
+	bl.SubConn
+	addr string
+}
+
+func newPicker(t *testing.T, weights map[string]string) *loadAwarePicker {
+	t.Helper()
+	info := base.PickerBuildInfo{ReadySCs: make(map[bl.SubConn]base.SubConnInfo)}
+	for addr, weight := range weights {
+		md := map[string]string{"weight": weight}
+		info.ReadySCs[&testSubConn{addr: addr}] = base.SubConnInfo{
+			Address: resolver.Address{Addr: addr, Metadata: &md},
+		}
+	}
+	pb := &loadAwarePickerBuilder{loads: make(map[string]*load), logger: logger.Default}
+	return pb.Build(info).(*loadAwarePicker)
+}
+
+// picks returns how many times each addr is picked, the load reported by
+// addr is sent back after each of its rpcs if any
+func picks(t *testing.T, p *loadAwarePicker, n int, reports map[string]loadreport.Report) map[string]int {
+	t.Helper()
+	count := map[string]int{}
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(bl.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := res.SubConn.(*testSubConn).addr
+		count[addr]++
+
+		info := bl.DoneInfo{}
+		if r, ok := reports[addr]; ok {
+			info.Trailer = r.MD()
+		}
+		res.Done(info)
+	}
+	return count
+}
+
+func TestPickWithoutReports(t *testing.T) {
+	p := newPicker(t, map[string]string{"a": "100", "b": "300"})
+	count := picks(t, p, 400, nil)
+	if count["a"] != 100 || count["b"] != 300 {
+		t.Errorf("picks = %v, want the registered weights", count)
+	}
+}
+
+func TestPickWithReports(t *testing.T) {
+	p := newPicker(t, map[string]string{"a": "100", "b": "100"})
+	reports := map[string]loadreport.Report{
+		"a": {CPU: 0.9},
+		"b": {CPU: 0.1},
+	}
+	// 每个实例至少收到一次上报
+	picks(t, p, 2, reports)
+
+	// 空闲度0.1和0.9，权重为100*(0.2+0.8*0.2)和100*(0.2+0.8*1.8)
+	count := picks(t, p, 200, reports)
+	if count["a"] != 36 || count["b"] != 164 {
+		t.Errorf("picks = %v, want a=36 b=164", count)
+	}
+}
+
+func TestPickStaleReports(t *testing.T) {
+	p := newPicker(t, map[string]string{"a": "100", "b": "100"})
+	picks(t, p, 2, map[string]loadreport.Report{"a": {CPU: 0.9}, "b": {CPU: 0.1}})
+
+	// 过期的上报不再生效，按注册权重均分
+	for _, wp := range p.peers {
+		wp.load.mu.Lock()
+		wp.load.updatedAt = time.Now().Add(-ReportTTL * 2)
+		wp.load.mu.Unlock()
+		wp.currentWeight = 0
+	}
+	count := picks(t, p, 200, nil)
+	if count["a"] != 100 || count["b"] != 100 {
+		t.Errorf("picks = %v, want a=100 b=100", count)
+	}
+}
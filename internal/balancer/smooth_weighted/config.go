@@ -0,0 +1,56 @@
+package smooth_weighted
+
+import (
+	"encoding/json"
+	"github.com/liuxp0827/grpc-lb/breaker"
+	"google.golang.org/grpc/serviceconfig"
+	"time"
+)
+
+// lbConfig is the config of the balancer in the service config, e.g.
+//
+//	{"loadBalancingConfig": [{"smooth_weighted_lb": {"circuitBreaker": {
+//		"failureThreshold": 5, "openTimeout": "10s", "halfOpenRequests": 1}}}]}
+type lbConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	CircuitBreaker *circuitBreakerConfig `json:"circuitBreaker,omitempty"`
+}
+
+type circuitBreakerConfig struct {
+	FailureThreshold int    `json:"failureThreshold"`
+	OpenTimeout      string `json:"openTimeout"`
+	HalfOpenRequests int    `json:"halfOpenRequests"`
+
+	openTimeout time.Duration
+}
+
+func parseConfig(js json.RawMessage) (*lbConfig, error) {
+	cfg := &lbConfig{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+
+	if cb := cfg.CircuitBreaker; cb != nil {
+		cb.openTimeout = breaker.DefaultConfig.OpenTimeout
+		if cb.OpenTimeout != "" {
+			d, err := time.ParseDuration(cb.OpenTimeout)
+			if err != nil {
+				return nil, err
+			}
+			cb.openTimeout = d
+		}
+	}
+	return cfg, nil
+}
+
+func (c *lbConfig) breakerConfig() breaker.Config {
+	if c.CircuitBreaker == nil {
+		return CircuitBreaker
+	}
+	return breaker.Config{
+		FailureThreshold: c.CircuitBreaker.FailureThreshold,
+		OpenTimeout:      c.CircuitBreaker.openTimeout,
+		HalfOpenRequests: c.CircuitBreaker.HalfOpenRequests,
+	}
+}
@@ -2,81 +2,258 @@ package smooth_weighted
 
 import (
 	"encoding/json"
-	"github.com/liuxp0827/grpc-lb/app"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/breaker"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	bl "google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const (
 	Name          = "smooth_weighted_lb"
 	WeightTag     = "weight"
+	ZoneTag       = "zone"
 	defaultWeight = 0
 )
 
-func newBuilder() bl.Builder {
-	return base.NewBalancerBuilderV2(Name, &smoothWeightPickerBuilder{}, base.Config{HealthCheck: true})
-}
+var (
+	// CircuitBreaker is used by targets without a circuitBreaker in their
+	// service config, the zero value disables the breakers.
+	CircuitBreaker breaker.Config
+	// Logger logs the breakers of a target opening and closing,
+	// logger.Default if nil
+	Logger logger.Logger
+)
 
 func init() {
-	bl.Register(newBuilder())
+	bl.Register(&smoothWeightBuilder{})
+}
+
+type smoothWeightBuilder struct{}
+
+func (*smoothWeightBuilder) Build(cc bl.ClientConn, opts bl.BuildOptions) bl.Balancer {
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
+	pb := &smoothWeightPickerBuilder{
+		target:   opts.Target.Endpoint,
+		cfg:      CircuitBreaker,
+		breakers: make(map[string]*breaker.Breaker),
+	}
+	pb.service.logger = logger.With(l, "balancer", Name, "target", opts.Target.Endpoint)
+	b := base.NewBalancerBuilderV2(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+	return &smoothWeightBalancer{
+		Balancer:   b,
+		v2:         b.(bl.V2Balancer),
+		pb:         pb,
+		unregister: admin.Balanced(Name, opts.Target.Endpoint, pb.peers),
+	}
+}
+
+func (*smoothWeightBuilder) Name() string {
+	return Name
 }
 
-type smoothWeightPickerBuilder struct{}
+func (*smoothWeightBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg, err := parseConfig(js)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// smoothWeightBalancer picks up the balancer config before handing the state
+// to the base balancer, which ignores it.
+type smoothWeightBalancer struct {
+	bl.Balancer
+	v2         bl.V2Balancer
+	pb         *smoothWeightPickerBuilder
+	unregister func()
+}
+
+func (b *smoothWeightBalancer) UpdateClientConnState(s bl.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*lbConfig); ok {
+		b.pb.setConfig(cfg.breakerConfig())
+	}
+	return b.v2.UpdateClientConnState(s)
+}
+
+func (b *smoothWeightBalancer) ResolverError(err error) {
+	b.v2.ResolverError(err)
+}
+
+func (b *smoothWeightBalancer) UpdateSubConnState(sc bl.SubConn, s bl.SubConnState) {
+	b.v2.UpdateSubConnState(sc, s)
+}
 
-func (*smoothWeightPickerBuilder) Build(info base.PickerBuildInfo) bl.V2Picker {
+func (b *smoothWeightBalancer) Close() {
+	b.unregister()
+	b.Balancer.Close()
+	b.pb.close()
+}
+
+type smoothWeightPickerBuilder struct {
+	target string
+
+	mu       sync.Mutex
+	cfg      breaker.Config
+	breakers map[string]*breaker.Breaker // addr -> breaker, kept across pickers
+	picks    map[string]picksCounter     // addr -> picks, kept across pickers
+	service  serviceState
+	picker   *smoothWeightPicker // the last one built, for the admin handler
+}
+
+func (b *smoothWeightPickerBuilder) setConfig(cfg breaker.Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cfg = cfg
+	for _, cb := range b.breakers {
+		cb.SetConfig(cfg)
+	}
+}
+
+type picksCounter struct {
+	prometheus.Counter
+	release func()
+}
+
+// close deletes the picks series of the addrs
+func (b *smoothWeightPickerBuilder) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for addr, c := range b.picks {
+		c.release()
+		delete(b.picks, addr)
+	}
+}
+
+func (b *smoothWeightPickerBuilder) Build(info base.PickerBuildInfo) bl.V2Picker {
 	if len(info.ReadySCs) == 0 {
+		b.mu.Lock()
+		b.picker = nil
+		b.mu.Unlock()
 		return base.NewErrPickerV2(bl.ErrNoSubConnAvailable)
 	}
 
-	p := smoothWeightPicker{}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := smoothWeightPicker{service: &b.service}
+	p.service.name = b.target
 
+	breakers := make(map[string]*breaker.Breaker, len(info.ReadySCs))
+	picks := make(map[string]picksCounter, len(info.ReadySCs))
 	p.weightPeers = make([]weightPeer, 0, len(info.ReadySCs))
 	for sc, info := range info.ReadySCs {
+		cb, ok := b.breakers[info.Address.Addr]
+		if !ok {
+			cb = breaker.New(fmt.Sprintf("%s/%s", b.target, info.Address.Addr), b.cfg)
+		}
+		breakers[info.Address.Addr] = cb
 
-		weight := defaultWeight
-
-		switch md := info.Address.Metadata.(type) {
-		case *map[string]string:
-			if md != nil {
-				weight = getWeight(*md)
-			}
-		case *app.Metadata:
-			if md != nil {
-				weight = getWeight(*md)
-			}
-		case string:
-			_md := loadMetadata(md)
-			weight = getWeight(_md)
+		c, ok := b.picks[info.Address.Addr]
+		if !ok {
+			c.Counter, c.release = metrics.Picks(Name, b.target, info.Address.Addr)
 		}
+		picks[info.Address.Addr] = c
 
 		wp := weightPeer{
 			subConn: sc,
-			weight:  weight,
+			addr:    info.Address.Addr,
+			zone:    metadata(info.Address)[ZoneTag],
+			weight:  Weight(info.Address),
+			breaker: cb,
+			picks:   c,
 		}
 
 		p.weightPeers = append(p.weightPeers, wp)
 	}
+	// 不再就绪的地址删除其series，避免随实例变化不断增长
+	for addr, c := range b.picks {
+		if _, ok := picks[addr]; !ok {
+			c.release()
+		}
+	}
+	b.breakers, b.picks = breakers, picks
+	b.picker = &p
 
 	return &p
 }
 
+// peers returns the state of the peers of the last picker
+func (b *smoothWeightPickerBuilder) peers() []admin.Peer {
+	b.mu.Lock()
+	p := b.picker
+	b.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers := make([]admin.Peer, 0, len(p.weightPeers))
+	for _, wp := range p.weightPeers {
+		peer := admin.Peer{
+			Addr:          wp.addr,
+			Weight:        float64(wp.weight),
+			CurrentWeight: float64(wp.currentWeight),
+			Breaker:       wp.breaker.State().String(),
+			Details:       map[string]interface{}{"effective_weight": wp.effectiveWeight},
+		}
+		if wp.zone != "" {
+			peer.Details["zone"] = wp.zone
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
 type weightPeer struct {
 	subConn         bl.SubConn
+	addr            string
+	zone            string
 	weight          int
 	effectiveWeight int
 	currentWeight   int
+	breaker         *breaker.Breaker
+	picks           prometheus.Counter
 }
 
 type smoothWeightPicker struct {
 	weightPeers []weightPeer
+	service     *serviceState
 	mu          sync.Mutex
 }
 
-func (p *smoothWeightPicker) Pick(bl.PickInfo) (bl.PickResult, error) {
+func (p *smoothWeightPicker) Pick(info bl.PickInfo) (bl.PickResult, error) {
 	if len(p.weightPeers) == 1 { // 如果只有一个peer，直接返回，避免锁竞争
-		return bl.PickResult{SubConn: p.weightPeers[0].subConn}, nil
+		wp := &p.weightPeers[0]
+		if !wp.breaker.Ready() {
+			return p.fail(info, "circuit breaker open for all instances")
+		}
+		if !wp.breaker.Allow() {
+			return p.reject(info, []string{wp.addr})
+		}
+		return p.result(info, wp)
 	}
 
 	p.mu.Lock()
@@ -86,6 +263,9 @@ func (p *smoothWeightPicker) Pick(bl.PickInfo) (bl.PickResult, error) {
 	total := 0
 	for i := 0; i < len(p.weightPeers); i++ {
 		wp := &p.weightPeers[i]
+		if !wp.breaker.Ready() { // 熔断中的peer不参与选择
+			continue
+		}
 
 		wp.currentWeight += wp.effectiveWeight
 		total += wp.effectiveWeight
@@ -96,15 +276,112 @@ func (p *smoothWeightPicker) Pick(bl.PickInfo) (bl.PickResult, error) {
 			best = i
 		}
 	}
-	p.weightPeers[best].currentWeight -= total
+	if best == -1 {
+		return p.fail(info, "circuit breaker open for all instances")
+	}
+
+	// 半开状态的peer可能拒绝这次请求，按currentWeight依次尝试其他peer
+	var rejected []string
+	tried := make(map[int]bool)
+	for best != -1 {
+		wp := &p.weightPeers[best]
+		if wp.breaker.Allow() {
+			wp.currentWeight -= total
+			return p.result(info, wp)
+		}
+		rejected = append(rejected, wp.addr)
+		tried[best] = true
+
+		best = -1
+		for i := range p.weightPeers {
+			if tried[i] || !p.weightPeers[i].breaker.Ready() {
+				continue
+			}
+			if best == -1 || p.weightPeers[i].currentWeight > p.weightPeers[best].currentWeight {
+				best = i
+			}
+		}
+	}
+	return p.reject(info, rejected)
+}
+
+func (p *smoothWeightPicker) result(info bl.PickInfo, wp *weightPeer) (bl.PickResult, error) {
+	p.service.set(breaker.StateClosed)
+	wp.picks.Inc()
+	tracing.Picked(info.Ctx, Name, wp.addr,
+		attribute.Int("grpc_lb.weight", wp.weight),
+		attribute.Int("grpc_lb.effective_weight", wp.effectiveWeight),
+		attribute.Int("grpc_lb.current_weight", wp.currentWeight),
+		attribute.String("grpc_lb.zone", wp.zone),
+		attribute.String("grpc_lb.breaker", wp.breaker.State().String()),
+	)
+
+	return bl.PickResult{
+		SubConn: wp.subConn,
+		Done: func(info bl.DoneInfo) {
+			wp.breaker.Done(isFailure(info.Err))
+		},
+	}, nil
+}
+
+// reject fails the rpc rejected by the half open breakers of the peers,
+// without opening the breaker of the service
+func (p *smoothWeightPicker) reject(info bl.PickInfo, addrs []string) (bl.PickResult, error) {
+	reason := "circuit breaker rejected " + strings.Join(addrs, ",")
+	tracing.PickFailed(info.Ctx, Name, reason)
+	return bl.PickResult{}, status.Errorf(codes.Unavailable, "%s of %s", reason, p.service.name)
+}
+
+func (p *smoothWeightPicker) fail(info bl.PickInfo, reason string) (bl.PickResult, error) {
+	p.service.set(breaker.StateOpen)
+	tracing.PickFailed(info.Ctx, Name, reason)
+	return bl.PickResult{}, status.Errorf(codes.Unavailable, "circuit breaker is open for all instances of %s", p.service.name)
+}
+
+// serviceState is open when the breakers of all the instances are open
+type serviceState struct {
+	name   string
+	state  int32
+	logger logger.Logger
+}
+
+func (s *serviceState) set(to breaker.State) {
+	from := breaker.State(atomic.SwapInt32(&s.state, int32(to)))
+	if from == to {
+		return
+	}
+	if to == breaker.StateOpen {
+		s.logger.Warn("circuit breaker is open for all instances")
+	} else {
+		s.logger.Info("circuit breaker closed", "from", from.String())
+	}
+	if breaker.OnStateChange != nil {
+		breaker.OnStateChange(s.name, from, to)
+	}
+}
+
+// isFailure reports whether the error is caused by the instance rather than
+// by the request itself.
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return true
+	}
+	return false
+}
 
-	return bl.PickResult{SubConn: p.weightPeers[best].subConn}, nil
+// Weight returns the weight carried in the address metadata, which may be
+// *map[string]string, *app.Metadata or a json encoded string.
+func Weight(addr resolver.Address) int {
+	return getWeight(metadata(addr))
 }
 
-func loadMetadata(md string) map[string]string {
-	m := map[string]string{}
-	json.Unmarshal([]byte(md), &m)
-	return m
+func metadata(addr resolver.Address) map[string]string {
+	md, _ := internalresolver.Metadata(addr.Metadata)
+	return md
 }
 
 func getWeight(md map[string]string) int {
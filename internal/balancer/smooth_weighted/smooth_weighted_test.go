@@ -0,0 +1,119 @@
+package smooth_weighted
+
+import (
+	"context"
+	"github.com/liuxp0827/grpc-lb/breaker"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/internal/tracing/tracingtest"
+	"github.com/liuxp0827/grpc-lb/logger"
+	bl "google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSubConn struct {
+	bl.SubConn
+	addr string
+}
+
+func TestPickSkipsOpenBreakers(t *testing.T) {
+	pb := &smoothWeightPickerBuilder{
+		target:   t.Name(),
+		cfg:      breaker.Config{FailureThreshold: 1, OpenTimeout: time.Hour, HalfOpenRequests: 1},
+		breakers: make(map[string]*breaker.Breaker),
+	}
+	pb.service.logger = logger.Default
+	info := base.PickerBuildInfo{ReadySCs: make(map[bl.SubConn]base.SubConnInfo)}
+	for _, addr := range []string{"a", "b"} {
+		md := map[string]string{WeightTag: "10"}
+		info.ReadySCs[&testSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{Addr: addr, Metadata: &md}}
+	}
+	p := pb.Build(info)
+
+	pick := func() (string, bl.PickResult, error) {
+		res, err := p.Pick(bl.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			return "", res, err
+		}
+		return res.SubConn.(*testSubConn).addr, res, nil
+	}
+	// 让addr的熔断器打开
+	open := func(addr string) {
+		for i := 0; i < 4; i++ {
+			got, res, err := pick()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == addr {
+				res.Done(bl.DoneInfo{Err: status.Error(codes.Unavailable, "down")})
+				return
+			}
+			res.Done(bl.DoneInfo{})
+		}
+		t.Fatalf("%s not picked", addr)
+	}
+
+	open("a")
+	for i := 0; i < 10; i++ {
+		got, res, err := pick()
+		if err != nil || got != "b" {
+			t.Fatalf("pick = %q, %v, want b", got, err)
+		}
+		res.Done(bl.DoneInfo{})
+	}
+
+	open("b")
+	if _, _, err := pick(); status.Code(err) != codes.Unavailable {
+		t.Errorf("err = %v, want Unavailable with all breakers open", err)
+	}
+}
+
+func TestRejectKeepsServiceState(t *testing.T) {
+	p := &smoothWeightPicker{service: &serviceState{name: "dev/echo", logger: logger.Default}}
+
+	_, err := p.reject(bl.PickInfo{Ctx: context.Background()}, []string{"10.0.0.1:8080"})
+	if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), "10.0.0.1:8080") {
+		t.Errorf("err = %v, want Unavailable naming the rejecting peer", err)
+	}
+	if s := breaker.State(p.service.state); s != breaker.StateClosed {
+		t.Errorf("service state = %v after a rejection, want closed", s)
+	}
+}
+
+func TestPickTracing(t *testing.T) {
+	rec := tracingtest.Install()
+	pb := &smoothWeightPickerBuilder{
+		target:   t.Name(),
+		cfg:      breaker.Config{FailureThreshold: 1, OpenTimeout: time.Hour, HalfOpenRequests: 1},
+		breakers: make(map[string]*breaker.Breaker),
+	}
+	pb.service.logger = logger.Default
+	md := map[string]string{WeightTag: "10", ZoneTag: "a"}
+	info := base.PickerBuildInfo{ReadySCs: map[bl.SubConn]base.SubConnInfo{
+		&testSubConn{addr: "a"}: {Address: resolver.Address{Addr: "a", Metadata: &md}},
+	}}
+	p := pb.Build(info)
+
+	ctx, span := tracing.Start(context.Background(), "rpc")
+	res, err := p.Pick(bl.PickInfo{Ctx: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Done(bl.DoneInfo{})
+	span.End()
+
+	spans := rec.Ended("rpc")
+	if len(spans) != 1 || len(spans[0].Events) != 1 {
+		t.Fatalf("spans = %+v, want one with the pick", spans)
+	}
+	ev := spans[0].Events[0]
+	if ev.Name != "grpc_lb.pick" || ev.Attributes["grpc_lb.addr"].AsString() != "a" ||
+		ev.Attributes["grpc_lb.weight"].AsInt64() != 10 || ev.Attributes["grpc_lb.zone"].AsString() != "a" {
+		t.Errorf("event = %+v", ev)
+	}
+}
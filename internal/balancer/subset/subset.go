@@ -0,0 +1,211 @@
+package subset
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/internal/balancer/smooth_weighted"
+	bl "google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"hash/fnv"
+	"os"
+	"sort"
+)
+
+const Name = "subset_lb"
+
+var (
+	// SubsetSize is the number of instances each client connects to,
+	// used by targets without a subsetSize in their service config.
+	SubsetSize = 10
+	// ChildPolicy is the balancer used within the subset.
+	ChildPolicy = smooth_weighted.Name
+	// ClientID identifies the client when choosing its subset, the same id
+	// always gets the same subset. It defaults to the hostname.
+	ClientID, _ = os.Hostname()
+)
+
+func init() {
+	bl.Register(&subsetBuilder{})
+}
+
+// lbConfig is the config of the balancer in the service config, e.g.
+//
+//	{"loadBalancingConfig": [{"subset_lb": {"subsetSize": 10,
+//		"childPolicy": "smooth_weighted_lb", "childConfig": {}}}]}
+type lbConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	SubsetSize  int             `json:"subsetSize"`
+	ChildPolicy string          `json:"childPolicy"`
+	ChildConfig json.RawMessage `json:"childConfig"`
+
+	childConfig serviceconfig.LoadBalancingConfig
+}
+
+type subsetBuilder struct{}
+
+func (*subsetBuilder) Build(cc bl.ClientConn, opts bl.BuildOptions) bl.Balancer {
+	return &subsetBalancer{
+		cc:       cc,
+		opts:     opts,
+		clientID: ClientID,
+	}
+}
+
+func (*subsetBuilder) Name() string {
+	return Name
+}
+
+func (*subsetBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &lbConfig{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.ChildPolicy == "" {
+		cfg.ChildPolicy = ChildPolicy
+	}
+	child := bl.Get(cfg.ChildPolicy)
+	if child == nil {
+		return nil, fmt.Errorf("subset: unknown child policy %q", cfg.ChildPolicy)
+	}
+
+	if parser, ok := child.(bl.ConfigParser); ok && len(cfg.ChildConfig) > 0 {
+		childCfg, err := parser.ParseConfig(cfg.ChildConfig)
+		if err != nil {
+			return nil, err
+		}
+		cfg.childConfig = childCfg
+	}
+	return cfg, nil
+}
+
+// subsetBalancer hands only a subset of the resolved addresses to its child
+// balancer, so that the child opens connections to at most size instances.
+type subsetBalancer struct {
+	cc       bl.ClientConn
+	opts     bl.BuildOptions
+	clientID string
+
+	childName string
+	child     bl.Balancer
+}
+
+func (b *subsetBalancer) UpdateClientConnState(s bl.ClientConnState) error {
+	size, childName := SubsetSize, ChildPolicy
+	var childCfg serviceconfig.LoadBalancingConfig
+	if cfg, ok := s.BalancerConfig.(*lbConfig); ok {
+		if cfg.SubsetSize > 0 {
+			size = cfg.SubsetSize
+		}
+		childName, childCfg = cfg.ChildPolicy, cfg.childConfig
+	}
+
+	if b.child == nil || b.childName != childName {
+		builder := bl.Get(childName)
+		if builder == nil {
+			return fmt.Errorf("subset: unknown child policy %q", childName)
+		}
+		if b.child != nil {
+			b.child.Close()
+		}
+		b.child, b.childName = builder.Build(b.cc, b.opts), childName
+	}
+
+	s.ResolverState.Addresses = Subset(s.ResolverState.Addresses, b.clientID, size)
+	s.BalancerConfig = childCfg
+
+	if v2, ok := b.child.(bl.V2Balancer); ok {
+		return v2.UpdateClientConnState(s)
+	}
+	b.child.HandleResolvedAddrs(s.ResolverState.Addresses, nil)
+	return nil
+}
+
+func (b *subsetBalancer) ResolverError(err error) {
+	if b.child == nil {
+		return
+	}
+	if v2, ok := b.child.(bl.V2Balancer); ok {
+		v2.ResolverError(err)
+		return
+	}
+	b.child.HandleResolvedAddrs(nil, err)
+}
+
+func (b *subsetBalancer) UpdateSubConnState(sc bl.SubConn, s bl.SubConnState) {
+	if b.child == nil {
+		return
+	}
+	if v2, ok := b.child.(bl.V2Balancer); ok {
+		v2.UpdateSubConnState(sc, s)
+		return
+	}
+	b.child.HandleSubConnStateChange(sc, s.ConnectivityState)
+}
+
+func (b *subsetBalancer) HandleSubConnStateChange(sc bl.SubConn, state connectivity.State) {
+	grpclog.Error("subset: HandleSubConnStateChange should not be called")
+}
+
+func (b *subsetBalancer) HandleResolvedAddrs([]resolver.Address, error) {
+	grpclog.Error("subset: HandleResolvedAddrs should not be called")
+}
+
+func (b *subsetBalancer) Close() {
+	if b.child != nil {
+		b.child.Close()
+	}
+}
+
+// Subset returns at most size addresses chosen by rendezvous hashing: every
+// address is ranked by the hash of the client id and the address, and the
+// client keeps the highest ranked ones. Unlike slicing a shuffled list, a
+// membership change only moves the clients of the addresses that joined or
+// left, and the subsets of different clients spread evenly over the fleet.
+func Subset(addrs []resolver.Address, clientID string, size int) []resolver.Address {
+	if size <= 0 || len(addrs) <= size {
+		return addrs
+	}
+
+	type ranked struct {
+		addr  resolver.Address
+		score uint64
+	}
+
+	ranks := make([]ranked, len(addrs))
+	for i, addr := range addrs {
+		ranks[i] = ranked{addr: addr, score: score(clientID, addr.Addr)}
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].score != ranks[j].score {
+			return ranks[i].score > ranks[j].score
+		}
+		return ranks[i].addr.Addr < ranks[j].addr.Addr
+	})
+
+	subset := make([]resolver.Address, size)
+	for i := range subset {
+		subset[i] = ranks[i].addr
+	}
+	return subset
+}
+
+func score(clientID, addr string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(clientID))
+	h.Write([]byte{0})
+	h.Write([]byte(addr))
+
+	// fnv的低位分布不够均匀，再做一次混合(splitmix64)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
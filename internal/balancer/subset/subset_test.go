@@ -0,0 +1,94 @@
+package subset
+
+import (
+	"fmt"
+	"google.golang.org/grpc/resolver"
+	"testing"
+)
+
+func addrs(from, to int) []resolver.Address {
+	as := make([]resolver.Address, 0, to-from)
+	for i := from; i < to; i++ {
+		as = append(as, resolver.Address{Addr: fmt.Sprintf("10.0.0.%d:8080", i)})
+	}
+	return as
+}
+
+func set(as []resolver.Address) map[string]bool {
+	m := make(map[string]bool, len(as))
+	for _, a := range as {
+		m[a.Addr] = true
+	}
+	return m
+}
+
+func TestSubsetDeterministic(t *testing.T) {
+	all := addrs(0, 100)
+	a := Subset(all, "client-1", 10)
+	if len(a) != 10 {
+		t.Fatalf("len(subset) = %d, want 10", len(a))
+	}
+
+	// 地址顺序不影响结果
+	reversed := make([]resolver.Address, len(all))
+	for i := range all {
+		reversed[len(all)-1-i] = all[i]
+	}
+	b := set(Subset(reversed, "client-1", 10))
+	for _, addr := range a {
+		if !b[addr.Addr] {
+			t.Fatalf("subset changed with the order of addresses: %s missing", addr.Addr)
+		}
+	}
+}
+
+func TestSubsetStable(t *testing.T) {
+	before := Subset(addrs(0, 100), "client-1", 10)
+
+	// 新增实例最多替换掉同等数量的旧实例
+	after := set(Subset(addrs(0, 105), "client-1", 10))
+	kept := 0
+	for _, addr := range before {
+		if after[addr.Addr] {
+			kept++
+		}
+	}
+	if kept < 5 {
+		t.Fatalf("only %d of 10 instances kept after adding 5 instances", kept)
+	}
+
+	// 移除不在子集中的实例，子集不变
+	remaining := make([]resolver.Address, 0, 100)
+	chosen := set(before)
+	removed := 0
+	for _, addr := range addrs(0, 100) {
+		if !chosen[addr.Addr] && removed < 20 {
+			removed++
+			continue
+		}
+		remaining = append(remaining, addr)
+	}
+	after = set(Subset(remaining, "client-1", 10))
+	for _, addr := range before {
+		if !after[addr.Addr] {
+			t.Fatalf("subset changed after removing instances outside of it: %s missing", addr.Addr)
+		}
+	}
+}
+
+func TestSubsetSpread(t *testing.T) {
+	all := addrs(0, 20)
+	conns := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		for _, addr := range Subset(all, fmt.Sprintf("client-%d", i), 5) {
+			conns[addr.Addr]++
+		}
+	}
+
+	// 平均每个实例50个连接
+	for addr, n := range conns {
+		if n < 20 || n > 80 {
+			t.Errorf("%s has %d clients, want about 50", addr, n)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/resolver"
+	"strings"
+	"sync"
+	"time"
+)
+
+const namespace = "grpc_lb"
+
+var (
+	registrations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "registry",
+		Name:      "registrations_active",
+		Help:      "Number of apps registered and not closed yet.",
+	}, []string{"registry", "service"})
+
+	renewDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "registry",
+		Name:      "renew_duration_seconds",
+		Help:      "Latency of the renewals of the registrations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"registry", "service"})
+
+	renewFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "registry",
+		Name:      "renew_failures_total",
+		Help:      "Number of failed renewals of the registrations.",
+	}, []string{"registry", "service"})
+
+	resolverUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "resolver",
+		Name:      "updates_total",
+		Help:      "Number of address updates pushed by the resolvers.",
+	}, []string{"scheme", "target"})
+
+	resolverErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "resolver",
+		Name:      "errors_total",
+		Help:      "Number of errors reported by the resolvers.",
+	}, []string{"scheme", "target"})
+
+	resolverAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "resolver",
+		Name:      "addresses",
+		Help:      "Number of addresses of the last update of the resolvers.",
+	}, []string{"scheme", "target"})
+
+	watchRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "resolver",
+		Name:      "watch_restarts_total",
+		Help:      "Number of watches of the registries restarted after an error.",
+	}, []string{"scheme", "target"})
+
+	backoffRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "resolver",
+		Name:      "backoff_retries_total",
+		Help:      "Number of retries after a backoff delay.",
+	}, []string{"scheme", "target"})
+
+	picks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "balancer",
+		Name:      "picks_total",
+		Help:      "Number of rpcs sent to each subconn.",
+	}, []string{"balancer", "target", "addr"})
+)
+
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		registrations,
+		renewDuration,
+		renewFailures,
+		resolverUpdates,
+		resolverErrors,
+		resolverAddresses,
+		watchRestarts,
+		backoffRetries,
+		picks,
+	}
+}
+
+// Registered counts an active registration, until the returned func is called
+func Registered(registry, service string) func() {
+	g := registrations.WithLabelValues(registry, service)
+	g.Inc()
+	return g.Dec
+}
+
+func Renewed(registry, service string, start time.Time, err error) {
+	renewDuration.WithLabelValues(registry, service).Observe(time.Since(start).Seconds())
+	if err != nil {
+		renewFailures.WithLabelValues(registry, service).Inc()
+	}
+}
+
+func WatchRestarted(scheme, target string) {
+	watchRestarts.WithLabelValues(scheme, target).Inc()
+}
+
+// Backoff counts the retries of the resolver of the target
+func Backoff(scheme, target string, backoff func(int) time.Duration) func(int) time.Duration {
+	c := backoffRetries.WithLabelValues(scheme, target)
+	return func(retries int) time.Duration {
+		c.Inc()
+		return backoff(retries)
+	}
+}
+
+// Picks counts the rpcs the balancer of the target sends to the addr, the
+// series is deleted once all the returned release funcs are called
+func Picks(balancer, target, addr string) (prometheus.Counter, func()) {
+	labels := []string{balancer, target, addr}
+	refs.acquire(labels)
+	var once sync.Once
+	return picks.WithLabelValues(labels...), func() {
+		once.Do(func() {
+			refs.release(labels, picks)
+		})
+	}
+}
+
+// Builder deletes the series of the targets of b once their last resolver is
+// closed, the resolvers of b use ClientConn and Backoff with target.Endpoint
+// as the target, or the one returned by Target if b implements Targeter. The
+// ClientConn is wrapped by ClientConn before Build.
+func Builder(b resolver.Builder) resolver.Builder {
+	return &builder{Builder: b}
+}
+
+// Targeter is implemented by the builders whose endpoint is not a good label,
+// like the address list of static
+type Targeter interface {
+	Target(target resolver.Target) string
+}
+
+type builder struct {
+	resolver.Builder
+}
+
+// Unwrap returns the wrapped builder, the resolvers built as a part of
+// another one use it not to be counted twice
+func (b *builder) Unwrap() resolver.Builder {
+	return b.Builder
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	label := target.Endpoint
+	if t, ok := b.Builder.(Targeter); ok {
+		label = t.Target(target)
+	}
+	labels := []string{b.Scheme(), label}
+	refs.acquire(labels)
+	r, err := b.Builder.Build(target, ClientConn(b.Scheme(), label, cc), opts)
+	if err != nil {
+		refs.release(labels, resolverVecs...)
+		return nil, err
+	}
+	return &closer{Resolver: r, labels: labels}, nil
+}
+
+type closer struct {
+	resolver.Resolver
+	labels []string
+	once   sync.Once
+}
+
+func (r *closer) Close() {
+	r.Resolver.Close()
+	r.once.Do(func() {
+		refs.release(r.labels, resolverVecs...)
+	})
+}
+
+type vec interface {
+	DeleteLabelValues(lvs ...string) bool
+}
+
+var resolverVecs = []vec{resolverUpdates, resolverErrors, resolverAddresses, watchRestarts, backoffRetries}
+
+// refs counts the users of the label values, the series are deleted when
+// there are none left, so that they do not pile up with the churn of the
+// targets and the instances
+var refs = &labelRefs{n: make(map[string]int)}
+
+type labelRefs struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+func key(labels []string) string {
+	return strings.Join(labels, "\x00")
+}
+
+func (r *labelRefs) acquire(labels []string) {
+	r.mu.Lock()
+	r.n[key(labels)]++
+	r.mu.Unlock()
+}
+
+func (r *labelRefs) release(labels []string, vecs ...vec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key(labels)
+	if r.n[k]--; r.n[k] > 0 {
+		return
+	}
+	delete(r.n, k)
+	for _, v := range vecs {
+		v.DeleteLabelValues(labels...)
+	}
+}
+
+// ClientConn counts the updates and the errors of the resolver of the target
+func ClientConn(scheme, target string, cc resolver.ClientConn) resolver.ClientConn {
+	return &clientConn{
+		ClientConn: cc,
+		updates:    resolverUpdates.WithLabelValues(scheme, target),
+		errors:     resolverErrors.WithLabelValues(scheme, target),
+		addresses:  resolverAddresses.WithLabelValues(scheme, target),
+	}
+}
+
+type clientConn struct {
+	resolver.ClientConn
+	updates   prometheus.Counter
+	errors    prometheus.Counter
+	addresses prometheus.Gauge
+}
+
+func (cc *clientConn) UpdateState(s resolver.State) {
+	cc.updates.Inc()
+	cc.addresses.Set(float64(len(s.Addresses)))
+	cc.ClientConn.UpdateState(s)
+}
+
+func (cc *clientConn) ReportError(err error) {
+	cc.errors.Inc()
+	cc.ClientConn.ReportError(err)
+}
+
+func (cc *clientConn) NewAddress(addrs []resolver.Address) {
+	cc.updates.Inc()
+	cc.addresses.Set(float64(len(addrs)))
+	cc.ClientConn.NewAddress(addrs)
+}
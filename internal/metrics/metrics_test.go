@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+)
+
+func TestPicksRelease(t *testing.T) {
+	c1, release1 := Picks("test", "dev/echo", "127.0.0.1:8080")
+	c2, release2 := Picks("test", "dev/echo", "127.0.0.1:8080")
+	c1.Inc()
+	c2.Inc()
+
+	release1()
+	release1()
+	if n := testutil.ToFloat64(picks.WithLabelValues("test", "dev/echo", "127.0.0.1:8080")); n != 2 {
+		t.Fatalf("picks = %v before the last release, want 2", n)
+	}
+
+	release2()
+	if n := testutil.ToFloat64(picks.WithLabelValues("test", "dev/echo", "127.0.0.1:8080")); n != 0 {
+		t.Fatalf("picks = %v after the last release, want 0", n)
+	}
+}
@@ -0,0 +1,41 @@
+// Package nacos shares the naming clients of the nacos resolvers and
+// registries of the process.
+package nacos
+
+import (
+	"fmt"
+	"github.com/nacos-group/nacos-sdk-go/clients"
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+	"sync"
+)
+
+// nacos-sdk-go v1的naming client没有Close，后台轮询和心跳的goroutine无法停止，
+// 相同服务器和配置的resolver和注册中心共用一个client，避免每次创建泄漏一个
+var (
+	mu    sync.Mutex
+	cache = make(map[string]naming_client.INamingClient)
+)
+
+// NamingClient returns the client of the servers and the config, created
+// once and shared, it is never closed
+func NamingClient(cfg constant.ClientConfig, servers []constant.ServerConfig) (naming_client.INamingClient, error) {
+	key := fmt.Sprintf("%+v|%+v", servers, cfg)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if client, ok := cache[key]; ok {
+		return client, nil
+	}
+	client, err := clients.NewNamingClient(vo.NacosClientParam{
+		ClientConfig:  &cfg,
+		ServerConfigs: servers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cache[key] = client
+	return client, nil
+}
@@ -0,0 +1,32 @@
+package resolver
+
+import (
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// ServiceConfig parses the service config of a resolver, and keeps the last
+// valid one to send in place of an invalid one.
+type ServiceConfig struct {
+	js     string
+	parsed *serviceconfig.ParseResult // 最后一次有效的配置
+}
+
+// Parse returns the parsed config of js, nil if empty. If js is invalid it
+// returns the error along with the last valid config, nil if none.
+func (c *ServiceConfig) Parse(cc resolver.ClientConn, js string) (*serviceconfig.ParseResult, error) {
+	if js == "" {
+		c.js, c.parsed = "", nil
+		return nil, nil
+	}
+	if js == c.js && c.parsed != nil {
+		return c.parsed, nil
+	}
+
+	sc := cc.ParseServiceConfig(js)
+	if sc.Err != nil {
+		return c.parsed, sc.Err
+	}
+	c.js, c.parsed = js, sc
+	return sc, nil
+}
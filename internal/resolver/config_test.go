@@ -0,0 +1,43 @@
+package resolver
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"testing"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+}
+
+func (cc *testClientConn) ParseServiceConfig(js string) *serviceconfig.ParseResult {
+	var v interface{}
+	if err := json.Unmarshal([]byte(js), &v); err != nil {
+		return &serviceconfig.ParseResult{Err: err}
+	}
+	return &serviceconfig.ParseResult{}
+}
+
+func TestServiceConfig(t *testing.T) {
+	var c ServiceConfig
+	cc := &testClientConn{}
+
+	// 没有有效的配置时不下发
+	if sc, err := c.Parse(cc, "{"); sc != nil || err == nil {
+		t.Errorf("config = %v, err = %v, want nil and an error", sc, err)
+	}
+
+	valid, err := c.Parse(cc, `{"loadBalancingPolicy":"round_robin"}`)
+	if valid == nil || err != nil {
+		t.Fatalf("config = %v, err = %v", valid, err)
+	}
+	// 无效的配置使用上一次有效的配置
+	if sc, err := c.Parse(cc, "{"); sc != valid || err == nil {
+		t.Errorf("config = %v, err = %v, want the last valid one and an error", sc, err)
+	}
+
+	if sc, err := c.Parse(cc, ""); sc != nil || err != nil {
+		t.Errorf("config = %v, err = %v, want none", sc, err)
+	}
+}
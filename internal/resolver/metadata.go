@@ -0,0 +1,52 @@
+// Package resolver holds the helpers shared by the resolvers.
+package resolver
+
+import (
+	"encoding/json"
+	"github.com/liuxp0827/grpc-lb/app"
+	"google.golang.org/grpc/resolver"
+	"reflect"
+)
+
+// ReuseMetadata replaces the metadata of each address with the one of the
+// same address in old if they are deeply equal. The metadata is hashed with
+// the address by grpc, so a new pointer would rebuild the connection of an
+// unchanged address. It returns the metadata to pass as old next time.
+func ReuseMetadata(old map[string]interface{}, addrs []resolver.Address) map[string]interface{} {
+	metadata := make(map[string]interface{}, len(addrs))
+	for i := range addrs {
+		addr := &addrs[i]
+		if md, ok := old[addr.Addr]; ok && reflect.DeepEqual(md, addr.Metadata) {
+			addr.Metadata = md
+		}
+		metadata[addr.Addr] = addr.Metadata
+	}
+	return metadata
+}
+
+// Metadata returns the metadata of an address, which may be
+// *map[string]string, *app.Metadata or a json encoded string. The map is not
+// copied, ok is false for the other types and an invalid json string.
+func Metadata(md interface{}) (m map[string]string, ok bool) {
+	switch md := md.(type) {
+	case *map[string]string:
+		if md != nil {
+			return *md, true
+		}
+	case *app.Metadata:
+		if md != nil {
+			return *md, true
+		}
+	case string:
+		m = map[string]string{}
+		if json.Unmarshal([]byte(md), &m) == nil {
+			return m, true
+		}
+		return nil, false
+	case nil:
+		return nil, true
+	default:
+		return nil, false
+	}
+	return nil, true
+}
@@ -0,0 +1,27 @@
+package resolver
+
+import (
+	"github.com/liuxp0827/grpc-lb/app"
+	"google.golang.org/grpc/resolver"
+	"testing"
+)
+
+func TestReuseMetadata(t *testing.T) {
+	addrs := []resolver.Address{
+		{Addr: "127.0.0.1:8080", Metadata: &app.Metadata{"weight": "100"}},
+		{Addr: "127.0.0.1:8081", Metadata: &app.Metadata{"weight": "100"}},
+	}
+	old := ReuseMetadata(nil, addrs)
+
+	next := []resolver.Address{
+		{Addr: "127.0.0.1:8080", Metadata: &app.Metadata{"weight": "100"}},
+		{Addr: "127.0.0.1:8081", Metadata: &app.Metadata{"weight": "50"}},
+	}
+	ReuseMetadata(old, next)
+	if next[0].Metadata != addrs[0].Metadata {
+		t.Error("metadata of the unchanged address not reused")
+	}
+	if next[1].Metadata == addrs[1].Metadata || (*next[1].Metadata.(*app.Metadata))["weight"] != "50" {
+		t.Errorf("metadata of the changed address = %v, want the new one", *next[1].Metadata.(*app.Metadata))
+	}
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/liuxp0827/grpc-lb"
+
+// Start starts a span with the global tracer provider, which records
+// nothing until otel.SetTracerProvider is called
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error if any and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Registration are the attributes of a registry span
+func Registration(registry, service, addr string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("grpc_lb.registry", registry),
+		attribute.String("grpc_lb.service", service),
+		attribute.String("grpc_lb.addr", addr),
+	}
+}
+
+// Resolution are the attributes of a resolver span
+func Resolution(scheme, target string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("grpc_lb.scheme", scheme),
+		attribute.String("grpc_lb.target", target),
+	}
+}
+
+// Resolved ends the span of a resolution with the number of addresses found
+func Resolved(span trace.Span, addrs int, err error) {
+	span.SetAttributes(attribute.Int("grpc_lb.addresses", addrs))
+	End(span, err)
+}
+
+// Picked adds the instance the balancer picked to the span of the rpc
+func Picked(ctx context.Context, balancer, addr string, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent("grpc_lb.pick", trace.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("grpc_lb.balancer", balancer),
+		attribute.String("grpc_lb.addr", addr),
+	}, attrs...)...))
+}
+
+// PickFailed adds the reason the balancer picked no instance to the span of the rpc
+func PickFailed(ctx context.Context, balancer, reason string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent("grpc_lb.pick_failed", trace.WithAttributes(
+		attribute.String("grpc_lb.balancer", balancer),
+		attribute.String("grpc_lb.reason", reason),
+	))
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/liuxp0827/grpc-lb/internal/tracing/tracingtest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"testing"
+)
+
+func TestRegistration(t *testing.T) {
+	rec := tracingtest.Install()
+
+	_, span := Start(context.Background(), "registry.Register", Registration("etcd", "dev/echo", "10.0.0.1:8080")...)
+	End(span, nil)
+	errRejected := errors.New("rejected")
+	_, span = Start(context.Background(), "registry.Register", Registration("etcd", "dev/echo", "10.0.0.2:8080")...)
+	End(span, errRejected)
+
+	spans := rec.Ended("registry.Register")
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(spans))
+	}
+	want := map[attribute.Key]string{"grpc_lb.registry": "etcd", "grpc_lb.service": "dev/echo", "grpc_lb.addr": "10.0.0.1:8080"}
+	for k, v := range want {
+		if got := spans[0].Attributes[k].AsString(); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if spans[0].Status != codes.Unset || len(spans[0].Errors) != 0 {
+		t.Errorf("status = %v, errors = %v, want unset without error", spans[0].Status, spans[0].Errors)
+	}
+	if spans[1].Status != codes.Error || spans[1].Message != "rejected" || len(spans[1].Errors) != 1 {
+		t.Errorf("status = %v %q, errors = %v, want the error", spans[1].Status, spans[1].Message, spans[1].Errors)
+	}
+}
+
+func TestResolved(t *testing.T) {
+	rec := tracingtest.Install()
+
+	_, span := Start(context.Background(), "resolver.List", Resolution("consul", "dev/echo")...)
+	Resolved(span, 3, nil)
+	_, span = Start(context.Background(), "resolver.List", Resolution("consul", "dev/echo")...)
+	Resolved(span, 0, errors.New("unavailable"))
+
+	spans := rec.Ended("resolver.List")
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(spans))
+	}
+	s := spans[0]
+	if s.Attributes["grpc_lb.scheme"].AsString() != "consul" || s.Attributes["grpc_lb.target"].AsString() != "dev/echo" ||
+		s.Attributes["grpc_lb.addresses"].AsInt64() != 3 || s.Status != codes.Unset {
+		t.Errorf("span = %+v", s)
+	}
+	if s := spans[1]; s.Attributes["grpc_lb.addresses"].AsInt64() != 0 || s.Status != codes.Error {
+		t.Errorf("span = %+v, want an error", s)
+	}
+}
+
+func TestPicked(t *testing.T) {
+	rec := tracingtest.Install()
+
+	// 没有span时不记录
+	Picked(context.Background(), "smooth_weighted_lb", "10.0.0.1:8080")
+
+	ctx, span := Start(context.Background(), "rpc")
+	Picked(ctx, "smooth_weighted_lb", "10.0.0.1:8080", attribute.Int("grpc_lb.weight", 10))
+	PickFailed(ctx, "smooth_weighted_lb", "all instances are down")
+	span.End()
+	// 结束后不再记录
+	Picked(ctx, "smooth_weighted_lb", "10.0.0.2:8080")
+
+	spans := rec.Ended("")
+	if len(spans) != 1 || len(spans[0].Events) != 2 {
+		t.Fatalf("spans = %+v, want one with 2 events", spans)
+	}
+	picked, failed := spans[0].Events[0], spans[0].Events[1]
+	if picked.Name != "grpc_lb.pick" || picked.Attributes["grpc_lb.balancer"].AsString() != "smooth_weighted_lb" ||
+		picked.Attributes["grpc_lb.addr"].AsString() != "10.0.0.1:8080" || picked.Attributes["grpc_lb.weight"].AsInt64() != 10 {
+		t.Errorf("event = %+v", picked)
+	}
+	if failed.Name != "grpc_lb.pick_failed" || failed.Attributes["grpc_lb.reason"].AsString() != "all instances are down" {
+		t.Errorf("event = %+v", failed)
+	}
+}
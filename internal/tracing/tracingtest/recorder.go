@@ -0,0 +1,138 @@
+// Package tracingtest records the spans in memory, to test the tracing of
+// the registries, the resolvers and the balancers.
+package tracingtest
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+// Recorder is a tracer provider keeping the ended spans
+type Recorder struct {
+	mu    sync.Mutex
+	ended []*Span
+}
+
+// Install sets a new recorder as the global tracer provider, the previous
+// one can't be restored since the global one may be set only once
+func Install() *Recorder {
+	r := &Recorder{}
+	otel.SetTracerProvider(r)
+	return r
+}
+
+func (r *Recorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return r
+}
+
+func (r *Recorder) Start(ctx context.Context, name string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	s := &Span{
+		Name:       name,
+		Attributes: make(map[attribute.Key]attribute.Value),
+		recorder:   r,
+	}
+	s.SetAttributes(trace.NewSpanConfig(opts...).Attributes...)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// Ended returns the ended spans named name, all of them if empty
+func (r *Recorder) Ended(name string) []*Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var spans []*Span
+	for _, s := range r.ended {
+		if name == "" || s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Span is a recorded span, its fields must not be read before it is ended
+type Span struct {
+	Name       string
+	Attributes map[attribute.Key]attribute.Value
+	Events     []Event
+	Status     codes.Code
+	Message    string
+	Errors     []error
+
+	mu       sync.Mutex
+	recorder *Recorder
+	ended    bool
+}
+
+// Event is an event added to a span
+type Event struct {
+	Name       string
+	Attributes map[attribute.Key]attribute.Value
+}
+
+func (s *Span) Tracer() trace.Tracer {
+	return s.recorder
+}
+
+func (s *Span) End(...trace.SpanOption) {
+	s.mu.Lock()
+	ended := s.ended
+	s.ended = true
+	s.mu.Unlock()
+	if ended {
+		return
+	}
+
+	s.recorder.mu.Lock()
+	s.recorder.ended = append(s.recorder.ended, s)
+	s.recorder.mu.Unlock()
+}
+
+func (s *Span) AddEvent(name string, opts ...trace.EventOption) {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range trace.NewEventConfig(opts...).Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	s.mu.Lock()
+	s.Events = append(s.Events, Event{Name: name, Attributes: attrs})
+	s.mu.Unlock()
+}
+
+func (s *Span) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.ended
+}
+
+func (s *Span) RecordError(err error, _ ...trace.EventOption) {
+	s.mu.Lock()
+	s.Errors = append(s.Errors, err)
+	s.mu.Unlock()
+}
+
+func (s *Span) SpanContext() trace.SpanContext {
+	return trace.SpanContext{}
+}
+
+func (s *Span) SetStatus(code codes.Code, msg string) {
+	s.mu.Lock()
+	s.Status, s.Message = code, msg
+	s.mu.Unlock()
+}
+
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	s.Name = name
+	s.mu.Unlock()
+}
+
+func (s *Span) SetAttributes(kv ...attribute.KeyValue) {
+	s.mu.Lock()
+	for _, a := range kv {
+		s.Attributes[a.Key] = a.Value
+	}
+	s.mu.Unlock()
+}
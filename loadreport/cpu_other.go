@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package loadreport
+
+// cpuSampler always reports an idle cpu on platforms without getrusage,
+// so only in-flight rpcs are taken into account.
+type cpuSampler struct{}
+
+func (s *cpuSampler) sample() float64 {
+	return 0
+}
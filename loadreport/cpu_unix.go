@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package loadreport
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// cpuSampler computes the cpu utilisation of the process between two samples
+type cpuSampler struct {
+	lastCPU  time.Duration
+	lastWall time.Time
+}
+
+func (s *cpuSampler) sample() float64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+
+	now := time.Now()
+	used := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+
+	var util float64
+	if !s.lastWall.IsZero() {
+		wall := now.Sub(s.lastWall) * time.Duration(runtime.NumCPU())
+		if wall > 0 {
+			util = float64(used-s.lastCPU) / float64(wall)
+		}
+	}
+
+	s.lastCPU, s.lastWall = used, now
+	return util
+}
@@ -0,0 +1,48 @@
+package loadreport
+
+import (
+	"google.golang.org/grpc/metadata"
+	"strconv"
+)
+
+// trailer keys carrying the server load, read by the load aware balancer
+const (
+	CPUKey      = "x-lb-cpu"
+	InFlightKey = "x-lb-inflight"
+	QPSKey      = "x-lb-qps"
+)
+
+type Report struct {
+	CPU      float64 // cpu utilisation of the process, in [0, 1]
+	InFlight int64   // rpcs being handled when the report was made
+	QPS      float64 // rpcs completed per second, averaged
+}
+
+func (r Report) MD() metadata.MD {
+	return metadata.Pairs(
+		CPUKey, strconv.FormatFloat(r.CPU, 'f', 4, 64),
+		InFlightKey, strconv.FormatInt(r.InFlight, 10),
+		QPSKey, strconv.FormatFloat(r.QPS, 'f', 2, 64),
+	)
+}
+
+// FromTrailer decodes the report attached by the server interceptors,
+// ok is false if the trailer carries no cpu utilisation.
+func FromTrailer(md metadata.MD) (r Report, ok bool) {
+	cpu := md.Get(CPUKey)
+	if len(cpu) == 0 {
+		return r, false
+	}
+
+	var err error
+	if r.CPU, err = strconv.ParseFloat(cpu[0], 64); err != nil {
+		return r, false
+	}
+	if v := md.Get(InFlightKey); len(v) > 0 {
+		r.InFlight, _ = strconv.ParseInt(v[0], 10, 64)
+	}
+	if v := md.Get(QPSKey); len(v) > 0 {
+		r.QPS, _ = strconv.ParseFloat(v[0], 64)
+	}
+	return r, true
+}
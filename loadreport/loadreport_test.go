@@ -0,0 +1,30 @@
+package loadreport
+
+import (
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+func TestTrailer(t *testing.T) {
+	r := Report{CPU: 0.4567, InFlight: 12, QPS: 345.67}
+	got, ok := FromTrailer(r.MD())
+	if !ok || got != r {
+		t.Errorf("FromTrailer(MD()) = %+v, %v, want %+v", got, ok, r)
+	}
+
+	for _, md := range []metadata.MD{
+		nil,
+		metadata.Pairs(InFlightKey, "3"),
+		metadata.Pairs(CPUKey, "high"),
+	} {
+		if r, ok := FromTrailer(md); ok {
+			t.Errorf("FromTrailer(%v) = %+v, want no report", md, r)
+		}
+	}
+
+	// 缺少的字段为0
+	got, ok = FromTrailer(metadata.Pairs(CPUKey, "0.5"))
+	if !ok || got != (Report{CPU: 0.5}) {
+		t.Errorf("FromTrailer(cpu only) = %+v, %v", got, ok)
+	}
+}
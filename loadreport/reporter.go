@@ -0,0 +1,112 @@
+package loadreport
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sampleInterval = time.Second
+	// 平滑系数，越大越偏向最近一次采样
+	decay = 0.5
+)
+
+// Reporter samples the load of the process and attaches it to the trailer
+// of every rpc handled by its interceptors.
+type Reporter struct {
+	inFlight  int64
+	completed int64
+
+	mu     sync.RWMutex
+	report Report
+
+	cpu      cpuSampler
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+func NewReporter() *Reporter {
+	r := &Reporter{
+		done: make(chan struct{}),
+	}
+	r.cpu.sample()
+
+	go r.run()
+
+	return r
+}
+
+func (r *Reporter) run() {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(last).Seconds()
+			last = now
+
+			qps := float64(atomic.SwapInt64(&r.completed, 0)) / elapsed
+			cpu := r.cpu.sample()
+
+			r.mu.Lock()
+			r.report.QPS = r.report.QPS*(1-decay) + qps*decay
+			r.report.CPU = math.Min(math.Max(r.report.CPU*(1-decay)+cpu*decay, 0), 1)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// Load returns the latest report, with the current number of in-flight rpcs.
+func (r *Reporter) Load() Report {
+	r.mu.RLock()
+	report := r.report
+	r.mu.RUnlock()
+
+	report.InFlight = atomic.LoadInt64(&r.inFlight)
+	return report
+}
+
+func (r *Reporter) begin() {
+	atomic.AddInt64(&r.inFlight, 1)
+}
+
+func (r *Reporter) end() {
+	atomic.AddInt64(&r.inFlight, -1)
+	atomic.AddInt64(&r.completed, 1)
+}
+
+func (r *Reporter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r.begin()
+		defer r.end()
+
+		resp, err := handler(ctx, req)
+		grpc.SetTrailer(ctx, r.Load().MD())
+		return resp, err
+	}
+}
+
+func (r *Reporter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r.begin()
+		defer r.end()
+
+		err := handler(srv, ss)
+		ss.SetTrailer(r.Load().MD())
+		return err
+	}
+}
+
+func (r *Reporter) Close() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
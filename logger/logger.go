@@ -0,0 +1,148 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+type Level int8
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Logger is a leveled logger taking the fields as alternating keys and
+// values, e.g. l.Error("failed to resolve addr", "target", target, "err", err).
+// *slog.Logger satisfies it, zap and logrus have adapters in the subpackages.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+var (
+	// Default is used by the registries without WithLogger and by the
+	// resolvers and balancers without their Logger set.
+	Default Logger = New(nil, LevelInfo)
+	// Nop discards everything
+	Nop Logger = nop{}
+)
+
+// New returns a logger writing to l, or the standard logger if nil, the
+// messages below level are dropped.
+func New(l *log.Logger, level Level) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+func (s *stdLogger) Debug(msg string, keyvals ...interface{}) { s.log(LevelDebug, msg, keyvals) }
+func (s *stdLogger) Info(msg string, keyvals ...interface{})  { s.log(LevelInfo, msg, keyvals) }
+func (s *stdLogger) Warn(msg string, keyvals ...interface{})  { s.log(LevelWarn, msg, keyvals) }
+func (s *stdLogger) Error(msg string, keyvals ...interface{}) { s.log(LevelError, msg, keyvals) }
+
+func (s *stdLogger) log(level Level, msg string, keyvals []interface{}) {
+	if level < s.level {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s", level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&b, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", keyvals[i])
+		}
+	}
+
+	if s.l == nil {
+		log.Print(b.String())
+		return
+	}
+	s.l.Print(b.String())
+}
+
+type nop struct{}
+
+func (nop) Debug(string, ...interface{}) {}
+func (nop) Info(string, ...interface{})  {}
+func (nop) Warn(string, ...interface{})  {}
+func (nop) Error(string, ...interface{}) {}
+
+// With returns a logger adding the keyvals to every message of l
+func With(l Logger, keyvals ...interface{}) Logger {
+	if len(keyvals) == 0 {
+		return l
+	}
+	if w, ok := l.(*withLogger); ok {
+		return &withLogger{l: w.l, keyvals: append(w.keyvals[:len(w.keyvals):len(w.keyvals)], keyvals...)}
+	}
+	return &withLogger{l: l, keyvals: keyvals}
+}
+
+type withLogger struct {
+	l       Logger
+	keyvals []interface{}
+}
+
+func (w *withLogger) Debug(msg string, keyvals ...interface{}) { w.l.Debug(msg, w.with(keyvals)...) }
+func (w *withLogger) Info(msg string, keyvals ...interface{})  { w.l.Info(msg, w.with(keyvals)...) }
+func (w *withLogger) Warn(msg string, keyvals ...interface{})  { w.l.Warn(msg, w.with(keyvals)...) }
+func (w *withLogger) Error(msg string, keyvals ...interface{}) { w.l.Error(msg, w.with(keyvals)...) }
+
+func (w *withLogger) with(keyvals []interface{}) []interface{} {
+	return append(w.keyvals[:len(w.keyvals):len(w.keyvals)], keyvals...)
+}
+
+// Printer is the logger of the clients only formatting messages, like the
+// zookeeper one.
+type Printer interface {
+	Printf(format string, args ...interface{})
+}
+
+// Printf returns a Printer logging the formatted messages at level
+func Printf(l Logger, level Level) Printer {
+	return printer{l: l, level: level}
+}
+
+type printer struct {
+	l     Logger
+	level Level
+}
+
+func (p printer) Printf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	switch p.level {
+	case LevelDebug:
+		p.l.Debug(msg)
+	case LevelInfo:
+		p.l.Info(msg)
+	case LevelWarn:
+		p.l.Warn(msg)
+	default:
+		p.l.Error(msg)
+	}
+}
@@ -0,0 +1,51 @@
+package logger_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.New(log.New(&buf, "", 0), logger.LevelInfo)
+
+	l.Debug("dropped")
+	l.Error("failed to resolve addr", "target", "dev/echo", "err", errors.New("timeout"))
+	logger.With(l, "scheme", "etcd").Warn("watch canceled", "rev", 3)
+	logger.Printf(l, logger.LevelInfo).Printf("connected to %s", "127.0.0.1:2181")
+
+	want := []string{
+		"[error] failed to resolve addr target=dev/echo err=timeout",
+		"[warn] watch canceled scheme=etcd rev=3",
+		"[info] connected to 127.0.0.1:2181",
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	var buf bytes.Buffer
+	base := logger.With(logger.New(log.New(&buf, "", 0), logger.LevelDebug), "target", "dev/echo")
+
+	// 两个子logger不能共享底层数组
+	a := logger.With(base, "dc", "dc1")
+	b := logger.With(base, "dc", "dc2")
+	a.Debug("a")
+	b.Debug("b")
+
+	want := "[debug] a target=dev/echo dc=dc1\n[debug] b target=dev/echo dc=dc2\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
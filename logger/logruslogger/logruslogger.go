@@ -0,0 +1,38 @@
+package logruslogger
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// New returns a logger writing to l, the keyvals become the fields of the
+// entries, and an error value is set as the logrus.ErrorKey field.
+func New(l logrus.FieldLogger) logger.Logger {
+	return &logrusLogger{l: l}
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+func (l *logrusLogger) Debug(msg string, keyvals ...interface{}) { l.entry(keyvals).Debug(msg) }
+func (l *logrusLogger) Info(msg string, keyvals ...interface{})  { l.entry(keyvals).Info(msg) }
+func (l *logrusLogger) Warn(msg string, keyvals ...interface{})  { l.entry(keyvals).Warn(msg) }
+func (l *logrusLogger) Error(msg string, keyvals ...interface{}) { l.entry(keyvals).Error(msg) }
+
+func (l *logrusLogger) entry(keyvals []interface{}) *logrus.Entry {
+	fields := make(logrus.Fields, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if i+1 == len(keyvals) {
+			fields["!BADKEY"] = keyvals[i]
+			break
+		}
+		if _, ok := keyvals[i+1].(error); ok && key == "err" {
+			key = logrus.ErrorKey
+		}
+		fields[key] = keyvals[i+1]
+	}
+	return l.l.WithFields(fields)
+}
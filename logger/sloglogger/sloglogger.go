@@ -0,0 +1,15 @@
+//go:build go1.21
+// +build go1.21
+
+package sloglogger
+
+import (
+	"github.com/liuxp0827/grpc-lb/logger"
+	"log/slog"
+)
+
+// New returns a logger writing to the handler h. A *slog.Logger can also be
+// used as a logger.Logger directly.
+func New(h slog.Handler) logger.Logger {
+	return slog.New(h)
+}
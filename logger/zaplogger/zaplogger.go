@@ -0,0 +1,21 @@
+package zaplogger
+
+import (
+	"github.com/liuxp0827/grpc-lb/logger"
+	"go.uber.org/zap"
+)
+
+// New returns a logger writing to the sugared logger of l, the keyvals
+// become the fields of the entries.
+func New(l *zap.Logger) logger.Logger {
+	return &zapLogger{s: l.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+type zapLogger struct {
+	s *zap.SugaredLogger
+}
+
+func (z *zapLogger) Debug(msg string, keyvals ...interface{}) { z.s.Debugw(msg, keyvals...) }
+func (z *zapLogger) Info(msg string, keyvals ...interface{})  { z.s.Infow(msg, keyvals...) }
+func (z *zapLogger) Warn(msg string, keyvals ...interface{})  { z.s.Warnw(msg, keyvals...) }
+func (z *zapLogger) Error(msg string, keyvals ...interface{}) { z.s.Errorw(msg, keyvals...) }
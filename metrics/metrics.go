@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers the collectors of the registries, the resolvers and
+// the balancers, e.g. with prometheus.DefaultRegisterer. Nothing is exposed
+// until it is called.
+func Register(reg prometheus.Registerer) error {
+	for _, c := range metrics.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+	"time"
+)
+
+func TestRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if err := Register(reg); err != nil {
+		t.Fatal(err)
+	}
+
+	r := memory.New(memory.WithStore(memory.NewStore(t.Name())))
+	r.Register(app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080})
+
+	active := func() float64 {
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() != "grpc_lb_registry_registrations_active" {
+				continue
+			}
+			for _, m := range mf.Metric {
+				for _, l := range m.Label {
+					if l.GetName() == "service" && l.GetValue() == "dev/echo" {
+						return m.GetGauge().GetValue()
+					}
+				}
+			}
+		}
+		return -1
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for active() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("registrations_active = %v, want 1", active())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	r.Close()
+	if v := active(); v != 0 {
+		t.Errorf("registrations_active = %v after close, want 0", v)
+	}
+}
@@ -1,15 +1,70 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 	"github.com/hashicorp/consul/api"
 	"github.com/liuxp0827/grpc-lb/app"
-	"github.com/liuxp0827/grpc-lb/internal/logger"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
 	"github.com/liuxp0827/grpc-lb/registry"
 	"sync"
 	"time"
 )
 
+// WithChecks sets the checks of every registration instead of the default
+// TTLCheck(10s), e.g. GRPCCheck(a, "", time.Second*5, time.Second) for an
+// active check of the grpc server. The ttl checks are kept passing by the registry.
+func WithChecks(checks func(a app.App) []*api.AgentServiceCheck) Option {
+	return func(opts *Options) {
+		opts.checks = checks
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(opts *Options) {
+		opts.l = l
+	}
+}
+
+type Option func(opts *Options)
+type Options struct {
+	checks func(a app.App) []*api.AgentServiceCheck
+	l      logger.Logger
+}
+
+// TTLCheck is a check the registry keeps passing while it runs
+func TTLCheck(ttl time.Duration) *api.AgentServiceCheck {
+	return &api.AgentServiceCheck{
+		TTL: ttl.String(),
+	}
+}
+
+// GRPCCheck is a check of the grpc health service of the app, for service
+// or the whole server if empty. Set GRPCUseTLS for a server with tls.
+func GRPCCheck(a app.App, service string, interval, timeout time.Duration) *api.AgentServiceCheck {
+	target := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+	if service != "" {
+		target += "/" + service
+	}
+	return &api.AgentServiceCheck{
+		GRPC:     target,
+		Interval: interval.String(),
+		Timeout:  timeout.String(),
+	}
+}
+
+// HTTPCheck is a check passing when a GET of the url returns a 2xx status
+func HTTPCheck(url string, interval, timeout time.Duration) *api.AgentServiceCheck {
+	return &api.AgentServiceCheck{
+		HTTP:     url,
+		Interval: interval.String(),
+		Timeout:  timeout.String(),
+	}
+}
+
 type consulRegistry struct {
 	mu       sync.Mutex
 	app    map[string]*app.App
@@ -17,10 +72,10 @@ type consulRegistry struct {
 	done     chan struct{}
 	client   *api.Client
 	wg       sync.WaitGroup
-	logger   logger.Logger
+	opts     *Options
 }
 
-func New(dc, addr string, l logger.Logger) (registry.Registry, error) {
+func New(dc, addr string, opts ...Option) (registry.Registry, error) {
 	if dc == "" {
 		dc = "dc1"
 	}
@@ -34,16 +89,27 @@ func New(dc, addr string, l logger.Logger) (registry.Registry, error) {
 		return nil, err
 	}
 
-	if l == nil {
-		l = logger.DefaultLogger
-	}
-
 	r := &consulRegistry{
 		app:  make(map[string]*app.App),
 		done:   make(chan struct{}),
 		client: client,
-		logger: l,
+		opts:   new(Options),
+	}
+
+	for _, opt := range opts {
+		opt(r.opts)
 	}
+
+	if r.opts.l == nil {
+		r.opts.l = logger.Default
+	}
+
+	if r.opts.checks == nil {
+		r.opts.checks = func(app.App) []*api.AgentServiceCheck {
+			return []*api.AgentServiceCheck{TTLCheck(time.Second * 10)}
+		}
+	}
+
 	return r, nil
 }
 
@@ -88,35 +154,24 @@ func (r *consulRegistry) Register(a app.App) <-chan error {
 	go func() {
 		defer r.wg.Done()
 
-		svcId := fmt.Sprintf("%s-%s-%d", a.Name, a.Addr, a.Port)
-		if len(a.Env) > 0 {
-			svcId = fmt.Sprintf("%s-%s", a.Env, svcId)
-		}
-		checkId := svcId
-		svcName := a.Name
-		if len(a.Env) > 0 {
-			svcName = fmt.Sprintf("%s/%s", a.Env, svcName)
-		}
+		reg := r.registration(a)
+		svcId := reg.ID
+		checkIds, interval := ttlChecks(reg.Checks)
 
-		err := r.client.Agent().ServiceRegister(&api.AgentServiceRegistration{
-			Kind:    api.ServiceKindTypical,
-			ID:      svcId,
-			Name:    svcName,
-			Address: a.Addr,
-			Port:    a.Port,
-			Meta:    a.Metadata.ToMap(),
-			Check: &api.AgentServiceCheck{
-				CheckID: checkId,
-				TTL:     "10s",
-			},
-		})
+		attrs := tracing.Registration("consul", a.Env+"/"+a.Name, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+		_, span := tracing.Start(context.Background(), "registry.Register", attrs...)
+		err := r.client.Agent().ServiceRegister(reg)
+		tracing.End(span, err)
 
 		if err != nil {
 			errCh <- err
 			return
 		}
 
-		tick := time.NewTicker(time.Second * 6)
+		defer metrics.Registered("consul", a.Env+"/"+a.Name)()
+		defer admin.Registered("consul", a)()
+
+		tick := time.NewTicker(interval)
 		defer tick.Stop()
 
 		renewRetryTimes := 0
@@ -125,13 +180,25 @@ func (r *consulRegistry) Register(a app.App) <-chan error {
 			//log.Printf("heartbeat tick")
 			select {
 			case <-r.done:
-				r.client.Agent().ServiceDeregister(svcId)
+				_, span := tracing.Start(context.Background(), "registry.Deregister", attrs...)
+				tracing.End(span, r.client.Agent().ServiceDeregister(svcId))
 				errCh <- registry.ErrRegistryClosed
 				break loop
 			case <-tick.C:
-				err := r.client.Agent().UpdateTTL(checkId, "pass", "pass")
+				if len(checkIds) == 0 {
+					continue
+				}
+				var err error
+				start := time.Now()
+				for _, checkId := range checkIds {
+					if err = r.client.Agent().UpdateTTL(checkId, "pass", "pass"); err != nil {
+						break
+					}
+				}
+				metrics.Renewed("consul", a.Env+"/"+a.Name, start, err)
+				admin.Renewed("consul", a, err)
 				if err != nil {
-					r.logger.Printf("[error] failed to update ttl, caused by: %s", err.Error())
+					r.opts.l.Error("failed to update ttl", "service", svcId, "err", err)
 					renewRetryTimes++
 					if renewRetryTimes > registry.MaxRenewRetry {
 						errCh <- registry.ErrFailedRenew
@@ -144,3 +211,106 @@ func (r *consulRegistry) Register(a app.App) <-chan error {
 
 	return errCh
 }
+
+// Update registers the app again with the same service id, keeping its checks passing
+func (r *consulRegistry) Update(a app.App) error {
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	r.mu.Lock()
+	old, ok := r.app[addr]
+	if ok {
+		a.Env, a.Name = old.Env, old.Name
+		r.app[addr] = &a
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return registry.ErrNotRegistered
+	}
+
+	// 重新注册的check状态为critical，直到下一次检查，主动检查也保持为passing
+	reg := r.registration(a)
+	for _, check := range reg.Checks {
+		check.Status = api.HealthPassing
+	}
+	if err := r.client.Agent().ServiceRegister(reg); err != nil {
+		return err
+	}
+	admin.Updated("consul", a)
+	return nil
+}
+
+// ttlChecks returns the ids of the ttl checks, and how often to renew them
+func ttlChecks(checks api.AgentServiceChecks) ([]string, time.Duration) {
+	var ids []string
+	interval := time.Second * 6
+	for _, check := range checks {
+		if check.TTL == "" {
+			continue
+		}
+		ids = append(ids, check.CheckID)
+		if ttl, err := time.ParseDuration(check.TTL); err == nil && ttl*2/3 < interval && ttl > 0 {
+			interval = ttl * 2 / 3
+		}
+	}
+	return ids, interval
+}
+
+func (r *consulRegistry) registration(a app.App) *api.AgentServiceRegistration {
+	return Registration(a, r.opts.checks(a))
+}
+
+// ServiceID is the id of the consul service the app is registered with, env-name-addr-port
+func ServiceID(a app.App) string {
+	svcId := fmt.Sprintf("%s-%s-%d", a.Name, a.Addr, a.Port)
+	if len(a.Env) > 0 {
+		svcId = fmt.Sprintf("%s-%s", a.Env, svcId)
+	}
+	return svcId
+}
+
+// ServiceName is the name of the consul service the app is registered with, env/name
+func ServiceName(a app.App) string {
+	svcName := a.Name
+	if len(a.Env) > 0 {
+		svcName = fmt.Sprintf("%s/%s", a.Env, svcName)
+	}
+	return svcName
+}
+
+// Registration is the registration of the app with the checks, whose ids
+// are set from the service id if empty
+func Registration(a app.App, checks []*api.AgentServiceCheck) *api.AgentServiceRegistration {
+	svcId, svcName := ServiceID(a), ServiceName(a)
+
+	// 状态保存在tag中，避免与metadata冲突
+	var tags []string
+	if a.Status != app.StatusUp {
+		tags = append(tags, a.Status)
+	}
+
+	// 第一个check的id与服务id相同，与之前的版本兼容
+	// 复制check，不修改WithChecks返回的check
+	copied := make([]*api.AgentServiceCheck, 0, len(checks))
+	for i, check := range checks {
+		c := *check
+		if c.CheckID == "" {
+			c.CheckID = svcId
+			if i > 0 {
+				c.CheckID = fmt.Sprintf("%s:%d", svcId, i)
+			}
+		}
+		copied = append(copied, &c)
+	}
+
+	return &api.AgentServiceRegistration{
+		Kind:    api.ServiceKindTypical,
+		ID:      svcId,
+		Name:    svcName,
+		Tags:    tags,
+		Address: a.Addr,
+		Port:    a.Port,
+		Meta:    a.Metadata.ToMap(),
+		Checks:  copied,
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/liuxp0827/grpc-lb/app"
-	"github.com/liuxp0827/grpc-lb/internal/logger"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
 	"github.com/liuxp0827/grpc-lb/registry"
 	"go.etcd.io/etcd/clientv3"
 	"path"
@@ -41,12 +44,12 @@ type Options struct {
 type Registry struct {
 	mu       sync.Mutex
 	apps     map[string]*app.App
+	leases   map[string]clientv3.LeaseID
 	doneOnce sync.Once
 	done     chan struct{}
 	client   *clientv3.Client
 	opts     *Options
 	wg       sync.WaitGroup
-	logger   logger.Logger
 }
 
 func New(cfg clientv3.Config, opts ...Option) (registry.Registry, error) {
@@ -58,6 +61,7 @@ func New(cfg clientv3.Config, opts ...Option) (registry.Registry, error) {
 
 	r := &Registry{
 		apps:   make(map[string]*app.App),
+		leases: make(map[string]clientv3.LeaseID),
 		done:   make(chan struct{}),
 		opts:   new(Options),
 		client: client,
@@ -76,7 +80,7 @@ func New(cfg clientv3.Config, opts ...Option) (registry.Registry, error) {
 	}
 
 	if r.opts.l == nil {
-		r.opts.l = logger.DefaultLogger
+		r.opts.l = logger.Default
 	}
 
 	return r, nil
@@ -113,24 +117,45 @@ func (r *Registry) Register(a app.App) <-chan error {
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		key := path.Join(r.opts.prefix, a.Env, a.Name, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+		// 注销、注册失败或者续租失败后lease不再有效，Update返回ErrNotRegistered
+		defer func() {
+			r.mu.Lock()
+			delete(r.leases, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+			r.mu.Unlock()
+		}()
+		key := Key(r.opts.prefix, a)
 		val := a.Encode()
-		cctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		attrs := tracing.Registration("etcd", a.Env+"/"+a.Name, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+		ctx, span := tracing.Start(context.Background(), "registry.Register", attrs...)
+
+		gctx, gspan := tracing.Start(ctx, "etcd.Grant")
+		cctx, cancel := context.WithTimeout(gctx, time.Second*3)
 		lease, err := r.client.Grant(cctx, r.opts.ttl)
 		cancel()
+		tracing.End(gspan, err)
 		if err != nil {
+			tracing.End(span, err)
 			errCh <- err
 			return
 		}
 
-		cctx, cancel = context.WithTimeout(context.Background(), time.Second*3)
+		pctx, pspan := tracing.Start(ctx, "etcd.Put")
+		cctx, cancel = context.WithTimeout(pctx, time.Second*3)
 		_, err = r.client.Put(cctx, key, val, clientv3.WithLease(lease.ID))
 		cancel()
+		tracing.End(pspan, err)
+		tracing.End(span, err)
 		if err != nil {
 			errCh <- err
 			return
 		}
 
+		r.mu.Lock()
+		r.leases[fmt.Sprintf("%s:%d", a.Addr, a.Port)] = lease.ID
+		r.mu.Unlock()
+		defer metrics.Registered("etcd", a.Env+"/"+a.Name)()
+		defer admin.Registered("etcd", a)()
+
 		ticker := time.NewTicker(time.Duration(r.opts.ttl*2/3) * time.Second)
 		defer ticker.Stop()
 
@@ -139,13 +164,18 @@ func (r *Registry) Register(a app.App) <-chan error {
 		for {
 			select {
 			case <-r.done:
-				r.client.Delete(context.Background(), key)
+				ctx, span := tracing.Start(context.Background(), "registry.Deregister", attrs...)
+				_, err := r.client.Delete(ctx, key)
+				tracing.End(span, err)
 				errCh <- registry.ErrRegistryClosed
 				break loop
 			case <-ticker.C:
+				start := time.Now()
 				_, err := r.client.KeepAliveOnce(context.Background(), lease.ID)
+				metrics.Renewed("etcd", a.Env+"/"+a.Name, start, err)
+				admin.Renewed("etcd", a, err)
 				if err != nil {
-					r.logger.Printf("[error] failed to update ttl, caused by: %s", err.Error())
+					r.opts.l.Error("failed to update ttl", "key", key, "err", err)
 					renewRetryTimes++
 					// 如果续租失败达到一定次数，认为分区了，这时候程序应终止
 					if renewRetryTimes > registry.MaxRenewRetry {
@@ -162,6 +192,39 @@ func (r *Registry) Register(a app.App) <-chan error {
 	return errCh
 }
 
+// Key is the key the app is put under, prefix/env/name/addr:port
+func Key(prefix string, a app.App) string {
+	return path.Join(prefix, a.Env, a.Name, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+}
+
+// Update puts the app again under the lease it was registered with
+func (r *Registry) Update(a app.App) error {
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	r.mu.Lock()
+	old, ok := r.apps[addr]
+	lease, granted := r.leases[addr]
+	if ok && granted {
+		a.Env, a.Name = old.Env, old.Name
+		r.apps[addr] = &a
+	}
+	r.mu.Unlock()
+
+	if !ok || !granted {
+		return registry.ErrNotRegistered
+	}
+
+	key := Key(r.opts.prefix, a)
+	cctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	_, err := r.client.Put(cctx, key, a.Encode(), clientv3.WithLease(lease))
+	cancel()
+	if err != nil {
+		return err
+	}
+	admin.Updated("etcd", a)
+	return nil
+}
+
 func (r *Registry) Close() error {
 	r.doneOnce.Do(func() {
 		close(r.done)
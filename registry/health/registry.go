@@ -0,0 +1,288 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+var (
+	// PollInterval is the interval of the Check of the health servers which
+	// don't implement Watch
+	PollInterval = time.Second
+	// Logger logs the failures to follow the health, logger.Default if nil
+	Logger logger.Logger
+)
+
+type instance struct {
+	a       app.App
+	serving bool
+}
+
+type healthRegistry struct {
+	r        registry.Registry
+	hs       healthpb.HealthServer
+	services []string
+
+	mu        sync.Mutex
+	instances map[string]*instance
+	doneOnce  sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+	logger    logger.Logger
+}
+
+// New makes the registration follow the health server, e.g. the one of
+// google.golang.org/grpc/health: an app is registered once all the services
+// (the whole server if none) are SERVING, marked draining when one is not
+// and marked up again when all are back. The services are followed with
+// Watch, or polled every PollInterval if hs doesn't implement it. r must
+// implement registry.Updater to follow the changes after the registration.
+func New(r registry.Registry, hs healthpb.HealthServer, services ...string) registry.Registry {
+	if len(services) == 0 {
+		services = []string{""}
+	}
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+	return &healthRegistry{
+		r:         r,
+		hs:        hs,
+		services:  services,
+		instances: make(map[string]*instance),
+		done:      make(chan struct{}),
+		logger:    l,
+	}
+}
+
+func (h *healthRegistry) serving() bool {
+	for _, svc := range h.services {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err := h.hs.Check(ctx, &healthpb.HealthCheckRequest{Service: svc})
+		cancel()
+		if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return false
+		}
+	}
+	return true
+}
+
+// watched is the status of the services of an app followed with Watch, or
+// polled with Check once the health server doesn't implement Watch
+type watched struct {
+	mu       sync.Mutex
+	statuses map[string]healthpb.HealthCheckResponse_ServingStatus
+	polling  bool
+	changed  chan struct{}
+}
+
+func (s *watched) set(svc string, st healthpb.HealthCheckResponse_ServingStatus) {
+	s.mu.Lock()
+	s.statuses[svc] = st
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *watched) poll() {
+	s.mu.Lock()
+	s.polling = true
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *watched) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// watch follows the services until ctx is done
+func (h *healthRegistry) watch(ctx context.Context) *watched {
+	s := &watched{
+		statuses: make(map[string]healthpb.HealthCheckResponse_ServingStatus),
+		changed:  make(chan struct{}, 1),
+	}
+	for _, svc := range h.services {
+		h.wg.Add(1)
+		go func(svc string) {
+			defer h.wg.Done()
+
+			err := h.hs.Watch(&healthpb.HealthCheckRequest{Service: svc}, &watchStream{ctx: ctx, send: func(resp *healthpb.HealthCheckResponse) {
+				s.set(svc, resp.Status)
+			}})
+			if ctx.Err() != nil {
+				return
+			}
+			// 不支持Watch或者Watch中断时改为定时Check
+			if status.Code(err) != codes.Unimplemented {
+				h.logger.Error("failed to watch the health, polling instead", "service", svc, "err", err)
+			}
+			s.poll()
+		}(svc)
+	}
+	return s
+}
+
+func (h *healthRegistry) followed(s *watched) bool {
+	s.mu.Lock()
+	polling := s.polling
+	serving := true
+	for _, svc := range h.services {
+		serving = serving && s.statuses[svc] == healthpb.HealthCheckResponse_SERVING
+	}
+	s.mu.Unlock()
+
+	if polling {
+		return h.serving()
+	}
+	return serving
+}
+
+// watchStream is the in-process stream of the Watch of the health server
+type watchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	send func(*healthpb.HealthCheckResponse)
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(resp *healthpb.HealthCheckResponse) error {
+	s.send(resp)
+	return nil
+}
+
+func (h *healthRegistry) Register(a app.App) <-chan error {
+	errCh := make(chan error, 1)
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	h.mu.Lock()
+	_, dup := h.instances[addr]
+	if !dup {
+		h.instances[addr] = &instance{a: a}
+	}
+	h.mu.Unlock()
+
+	if dup {
+		errCh <- registry.ErrDupRegister
+		return errCh
+	}
+
+	select {
+	case <-h.done:
+		errCh <- registry.ErrRegistryClosed
+		return errCh
+	default:
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w := h.watch(ctx)
+		ticker := time.NewTicker(PollInterval)
+		defer ticker.Stop()
+
+		// 健康检查通过后才注册
+		for !h.followed(w) {
+			select {
+			case <-h.done:
+				errCh <- registry.ErrRegistryClosed
+				return
+			case <-w.changed:
+			case <-ticker.C:
+			}
+		}
+
+		h.mu.Lock()
+		inst := h.instances[addr]
+		inst.serving = true
+		regCh := h.r.Register(inst.a)
+		h.mu.Unlock()
+
+		for {
+			select {
+			case err := <-regCh:
+				errCh <- err
+				return
+			case <-h.done:
+				errCh <- <-regCh
+				return
+			case <-w.changed:
+			case <-ticker.C:
+			}
+
+			serving := h.followed(w)
+			h.mu.Lock()
+			if serving == inst.serving {
+				h.mu.Unlock()
+				continue
+			}
+			inst.serving = serving
+			a := inst.a
+			h.mu.Unlock()
+
+			if !serving {
+				a.Status = app.StatusDraining
+			}
+			if err := h.update(a); err != nil {
+				h.logger.Error("failed to follow the health", "addr", addr, "err", err)
+			}
+		}
+	}()
+
+	return errCh
+}
+
+func (h *healthRegistry) update(a app.App) error {
+	u, ok := h.r.(registry.Updater)
+	if !ok {
+		return registry.ErrNotSupported
+	}
+	return u.Update(a)
+}
+
+// Update updates the app, which stays draining while it is not healthy
+func (h *healthRegistry) Update(a app.App) error {
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	h.mu.Lock()
+	inst, ok := h.instances[addr]
+	serving := ok && inst.serving
+	if ok {
+		inst.a = a
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		return registry.ErrNotRegistered
+	}
+	if !serving { // 还未注册或者不健康
+		return nil
+	}
+	return h.update(a)
+}
+
+func (h *healthRegistry) Close() error {
+	var err error
+	h.doneOnce.Do(func() {
+		close(h.done)
+		err = h.r.Close()
+		h.wg.Wait()
+	})
+	return err
+}
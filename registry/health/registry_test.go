@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+	"time"
+)
+
+// checkOnly is a health server without Watch
+type checkOnly struct {
+	*healthpb.UnimplementedHealthServer
+	hs *health.Server
+}
+
+func (s checkOnly) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	return s.hs.Check(ctx, req)
+}
+
+func TestHealth(t *testing.T) {
+	testHealth(t, func(hs *health.Server) healthpb.HealthServer { return hs })
+}
+
+// 不支持Watch时定时Check
+func TestPolling(t *testing.T) {
+	testHealth(t, func(hs *health.Server) healthpb.HealthServer {
+		return checkOnly{UnimplementedHealthServer: &healthpb.UnimplementedHealthServer{}, hs: hs}
+	})
+}
+
+func testHealth(t *testing.T, server func(*health.Server) healthpb.HealthServer) {
+	defer func(interval time.Duration) { PollInterval = interval }(PollInterval)
+	PollInterval = time.Millisecond * 10
+
+	store := memory.NewStore(t.Name())
+	hs := health.NewServer()
+	hs.SetServingStatus("echo", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	r := New(memory.New(memory.WithStore(store)), server(hs), "echo")
+	a := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080}
+	errCh := r.Register(a)
+
+	wait := func(registered, draining bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second * 5)
+		for {
+			apps := store.Apps("dev", "echo")
+			if (len(apps) == 1) == registered && (!registered || apps[0].Draining() == draining) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("apps = %v, want registered %v, draining %v", apps, registered, draining)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// 健康检查通过前不注册
+	time.Sleep(time.Millisecond * 50)
+	wait(false, false)
+
+	hs.SetServingStatus("echo", healthpb.HealthCheckResponse_SERVING)
+	wait(true, false)
+
+	hs.SetServingStatus("echo", healthpb.HealthCheckResponse_NOT_SERVING)
+	wait(true, true)
+
+	hs.SetServingStatus("echo", healthpb.HealthCheckResponse_SERVING)
+	wait(true, false)
+
+	// 主动摘流后不因健康检查恢复
+	if err := registry.Drain(r, a); err != nil {
+		t.Fatal(err)
+	}
+	wait(true, true)
+	hs.SetServingStatus("echo", healthpb.HealthCheckResponse_NOT_SERVING)
+	time.Sleep(time.Millisecond * 50)
+	hs.SetServingStatus("echo", healthpb.HealthCheckResponse_SERVING)
+	time.Sleep(time.Millisecond * 50)
+	wait(true, true)
+
+	r.Close()
+	if err := <-errCh; err != registry.ErrRegistryClosed {
+		t.Errorf("err = %v, want %v", err, registry.ErrRegistryClosed)
+	}
+	wait(false, false)
+}
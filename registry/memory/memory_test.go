@@ -0,0 +1,211 @@
+package memory_test
+
+import (
+	"errors"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/tracing/tracingtest"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/liuxp0827/grpc-lb/resolver/memory"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) {
+	cc.states <- s
+}
+
+func (cc *testClientConn) ReportError(error) {}
+
+func (cc *testClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func (cc *testClientConn) wait(t *testing.T, addrs ...string) {
+	t.Helper()
+	for {
+		select {
+		case s := <-cc.states:
+			if len(s.Addresses) != len(addrs) {
+				continue
+			}
+			match := true
+			for i := range addrs {
+				match = match && s.Addresses[i].Addr == addrs[i]
+			}
+			if match {
+				return
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timeout waiting for %v", addrs)
+		}
+	}
+}
+
+func build(t *testing.T, target string) (*testClientConn, resolver.Resolver) {
+	t.Helper()
+	cc := &testClientConn{states: make(chan resolver.State, 10)}
+	r, err := resolver.Get("memory").Build(resolver.Target{Scheme: "memory", Authority: t.Name(), Endpoint: target}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, r
+}
+
+func TestFailover(t *testing.T) {
+	store := memory.NewStore(t.Name())
+	r := memory.New(memory.WithStore(store), memory.WithTTL(time.Millisecond*30))
+	defer r.Close()
+
+	a1 := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080}
+	a2 := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8081}
+	errCh1 := r.Register(a1)
+	r.Register(a2)
+
+	cc, res := build(t, "dev/echo")
+	defer res.Close()
+	cc.wait(t, "127.0.0.1:8080", "127.0.0.1:8081")
+
+	// 摘流的实例不再返回
+	if err := registry.Drain(r, a2); err != nil {
+		t.Fatal(err)
+	}
+	cc.wait(t, "127.0.0.1:8080")
+
+	// 续租失败，租约过期后实例被删除
+	errRenew := errors.New("partitioned")
+	store.SetHooks(memory.Hooks{Renew: func(a app.App) error {
+		if a.Port == a1.Port {
+			return errRenew
+		}
+		return nil
+	}})
+	cc.wait(t)
+	select {
+	case err := <-errCh1:
+		if err != registry.ErrFailedRenew {
+			t.Errorf("err = %v, want %v", err, registry.ErrFailedRenew)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for the renewal to fail")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	store := memory.NewStore(t.Name())
+	r := memory.New(memory.WithStore(store))
+	defer r.Close()
+
+	errRejected := errors.New("rejected")
+	store.SetHooks(memory.Hooks{
+		// hook中可以调用store的方法
+		Register: func(a app.App) error {
+			if len(store.Apps(a.Env, a.Name)) == 0 {
+				return errRejected
+			}
+			return nil
+		},
+		NotifyDelay: time.Millisecond * 50,
+	})
+	a := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080}
+	if err := <-r.Register(a); err != errRejected {
+		t.Fatalf("err = %v, want %v", err, errRejected)
+	}
+
+	store.SetHooks(memory.Hooks{NotifyDelay: time.Millisecond * 50})
+	cc, res := build(t, "dev/echo")
+	defer res.Close()
+	cc.wait(t)
+
+	r2 := memory.New(memory.WithStore(store))
+	defer r2.Close()
+	start := time.Now()
+	r2.Register(a)
+	cc.wait(t, "127.0.0.1:8080")
+	if d := time.Since(start); d < time.Millisecond*50 {
+		t.Errorf("notified after %s, want at least 50ms", d)
+	}
+
+	store.Expire(a)
+	cc.wait(t)
+	if apps := store.Apps("dev", "echo"); len(apps) != 0 {
+		t.Errorf("apps = %v, want none", apps)
+	}
+}
+
+func TestNotifyDelayLatest(t *testing.T) {
+	store := memory.NewStore(t.Name())
+	store.SetHooks(memory.Hooks{NotifyDelay: time.Millisecond * 20})
+	r := memory.New(memory.WithStore(store))
+	defer r.Close()
+
+	ch, cancel := store.Watch("dev", "echo")
+	defer cancel()
+	<-ch
+
+	// 延迟期间的多次变更，最后收到的必须是最新的列表
+	a := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080}
+	r.Register(a)
+	for i := 0; i < 10; i++ {
+		a.Metadata = app.Metadata{"weight": strconv.Itoa(i)}
+		if err := r.(registry.Updater).Update(a); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 3)
+	}
+
+	var apps []app.App
+	timeout := time.After(time.Second)
+	for len(apps) != 1 || apps[0].Metadata["weight"] != "9" {
+		select {
+		case apps = <-ch:
+		case <-timeout:
+			t.Fatalf("apps = %v, want weight 9", apps)
+		}
+	}
+	select {
+	case apps = <-ch:
+		t.Errorf("got %v after the latest list", apps)
+	case <-time.After(time.Millisecond * 60):
+	}
+}
+
+func TestTracing(t *testing.T) {
+	rec := tracingtest.Install()
+	store := memory.NewStore(t.Name())
+	r := memory.New(memory.WithStore(store))
+
+	errRejected := errors.New("rejected")
+	store.SetHooks(memory.Hooks{Register: func(app.App) error { return errRejected }})
+	a := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080}
+	<-r.Register(a)
+	store.SetHooks(memory.Hooks{})
+	errCh := r.Register(app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8081})
+	r.Close()
+	<-errCh
+
+	spans := rec.Ended("registry.Register")
+	if len(spans) != 2 {
+		t.Fatalf("%d register spans, want 2", len(spans))
+	}
+	if s := spans[0]; s.Attributes["grpc_lb.registry"].AsString() != "memory" || s.Attributes["grpc_lb.service"].AsString() != "dev/echo" ||
+		s.Attributes["grpc_lb.addr"].AsString() != "127.0.0.1:8080" || s.Status != codes.Error || len(s.Errors) != 1 {
+		t.Errorf("span = %+v, want the rejected registration", s)
+	}
+	if s := spans[1]; s.Attributes["grpc_lb.addr"].AsString() != "127.0.0.1:8081" || s.Status != codes.Unset {
+		t.Errorf("span = %+v, want the registration", s)
+	}
+	if len(rec.Ended("registry.Deregister")) != 1 {
+		t.Errorf("deregister spans = %v, want 1", rec.Ended("registry.Deregister"))
+	}
+}
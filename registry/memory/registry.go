@@ -0,0 +1,184 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"sync"
+	"time"
+)
+
+// set the store the apps are registered in, DefaultStore by default
+func WithStore(s *Store) Option {
+	return func(opts *Options) {
+		opts.store = s
+	}
+}
+
+func WithTTL(ttl time.Duration) Option {
+	return func(opts *Options) {
+		opts.ttl = ttl
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(opts *Options) {
+		opts.l = l
+	}
+}
+
+type Option func(opts *Options)
+type Options struct {
+	store *Store
+	ttl   time.Duration
+	l     logger.Logger
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	apps     map[string]*app.App
+	doneOnce sync.Once
+	done     chan struct{}
+	opts     *Options
+	wg       sync.WaitGroup
+}
+
+// New creates a registry keeping the apps in a store of the process, the
+// leases are renewed like the etcd ones so that the hooks of the store can
+// fail them.
+func New(opts ...Option) registry.Registry {
+	r := &Registry{
+		apps: make(map[string]*app.App),
+		done: make(chan struct{}),
+		opts: new(Options),
+	}
+
+	for _, opt := range opts {
+		opt(r.opts)
+	}
+
+	if r.opts.store == nil {
+		r.opts.store = DefaultStore
+	}
+
+	if r.opts.ttl <= 0 {
+		r.opts.ttl = time.Second * 10
+	}
+
+	if r.opts.l == nil {
+		r.opts.l = logger.Default
+	}
+
+	return r
+}
+
+func (r *Registry) Register(a app.App) <-chan error {
+	errCh := make(chan error, 1)
+
+	if dup := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+		_, dup := r.apps[addr]
+		if dup {
+			return true
+		}
+		r.apps[addr] = &a
+
+		return false
+	}(); dup {
+		errCh <- registry.ErrDupRegister
+		return errCh
+	}
+
+	select {
+	case <-r.done:
+		errCh <- registry.ErrRegistryClosed
+		return errCh
+	default:
+	}
+
+	attrs := tracing.Registration("memory", a.Env+"/"+a.Name, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+	_, span := tracing.Start(context.Background(), "registry.Register", attrs...)
+	err := r.opts.store.put(a, r.opts.ttl)
+	tracing.End(span, err)
+	if err != nil {
+		errCh <- err
+		return errCh
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer metrics.Registered("memory", a.Env+"/"+a.Name)()
+		defer admin.Registered("memory", a)()
+
+		ticker := time.NewTicker(r.opts.ttl * 2 / 3)
+		defer ticker.Stop()
+
+		renewRetryTimes := 0
+		for {
+			select {
+			case <-r.done:
+				_, span := tracing.Start(context.Background(), "registry.Deregister", attrs...)
+				r.opts.store.delete(a)
+				tracing.End(span, nil)
+				errCh <- registry.ErrRegistryClosed
+				return
+			case <-ticker.C:
+				start := time.Now()
+				err := r.opts.store.renew(a, r.opts.ttl)
+				metrics.Renewed("memory", a.Env+"/"+a.Name, start, err)
+				admin.Renewed("memory", a, err)
+				if err != nil {
+					r.opts.l.Error("failed to update ttl", "addr", fmt.Sprintf("%s:%d", a.Addr, a.Port), "err", err)
+					renewRetryTimes++
+					if renewRetryTimes > registry.MaxRenewRetry {
+						errCh <- registry.ErrFailedRenew
+						return
+					}
+				} else {
+					renewRetryTimes = 0
+				}
+			}
+		}
+	}()
+
+	return errCh
+}
+
+func (r *Registry) Update(a app.App) error {
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	r.mu.Lock()
+	old, ok := r.apps[addr]
+	if ok {
+		a.Env, a.Name = old.Env, old.Name
+		r.apps[addr] = &a
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return registry.ErrNotRegistered
+	}
+	if err := r.opts.store.update(a); err != nil {
+		return err
+	}
+	admin.Updated("memory", a)
+	return nil
+}
+
+func (r *Registry) Close() error {
+	r.doneOnce.Do(func() {
+		close(r.done)
+		r.wg.Wait()
+	})
+	return nil
+}
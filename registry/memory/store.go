@@ -0,0 +1,303 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// Hooks inject failures and delays into a store, to test how servers and
+// clients behave when the registry misbehaves. The hooks are called without
+// holding the lock of the store, they may call its methods.
+type Hooks struct {
+	// Register is called before an app is stored, an error fails the registration
+	Register func(a app.App) error
+	// Renew is called before the lease of an app is renewed, an error fails the renewal
+	Renew func(a app.App) error
+	// NotifyDelay delays the notification of the watchers after a change, the
+	// changes made meanwhile are delivered with it as the latest list
+	NotifyDelay time.Duration
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = make(map[string]*Store)
+)
+
+// DefaultStore is used by registries without WithStore and by memory:///env/name targets
+var DefaultStore = NewStore("")
+
+// Store holds the registered apps in process, it is shared by the memory
+// registries and by the resolvers of the memory:// targets naming it.
+type Store struct {
+	name string
+
+	mu       sync.Mutex
+	hooks    Hooks
+	services map[string]map[string]*lease // env/name -> addr:port -> lease
+	watchers map[string]map[*watcher]struct{}
+}
+
+type lease struct {
+	app   app.App
+	timer *time.Timer
+}
+
+type watcher struct {
+	ch      chan []app.App
+	done    chan struct{}
+	pending *time.Timer // 延迟中的通知，触发时发送最新的列表
+}
+
+// send replaces the list not read yet, it must be called with s.mu held
+func (w *watcher) send(apps []app.App) {
+	select {
+	case <-w.ch: // 丢弃未读取的旧列表
+	default:
+	}
+	select {
+	case w.ch <- apps:
+	case <-w.done:
+	}
+}
+
+// NewStore creates a store, which replaces any store with the same name for
+// the resolvers of memory://name/env/name targets.
+func NewStore(name string) *Store {
+	s := &Store{
+		name:     name,
+		services: make(map[string]map[string]*lease),
+		watchers: make(map[string]map[*watcher]struct{}),
+	}
+
+	storesMu.Lock()
+	stores[name] = s
+	storesMu.Unlock()
+
+	return s
+}
+
+// GetStore returns the store created with the name
+func GetStore(name string) (*Store, bool) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	s, ok := stores[name]
+	return s, ok
+}
+
+func (s *Store) Name() string {
+	return s.name
+}
+
+func (s *Store) SetHooks(h Hooks) {
+	s.mu.Lock()
+	s.hooks = h
+	s.mu.Unlock()
+}
+
+// hook calls the hook chosen by f without holding s.mu, so that it can call
+// the methods of the store
+func (s *Store) hook(f func(h Hooks) func(app.App) error, a app.App) error {
+	s.mu.Lock()
+	hook := f(s.hooks)
+	s.mu.Unlock()
+
+	if hook == nil {
+		return nil
+	}
+	return hook(a)
+}
+
+func service(a app.App) string {
+	return a.Env + "/" + a.Name
+}
+
+func addr(a app.App) string {
+	return fmt.Sprintf("%s:%d", a.Addr, a.Port)
+}
+
+func (s *Store) put(a app.App, ttl time.Duration) error {
+	if err := s.hook(func(h Hooks) func(app.App) error { return h.Register }, a); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc := service(a)
+	leases, ok := s.services[svc]
+	if !ok {
+		leases = make(map[string]*lease)
+		s.services[svc] = leases
+	}
+	if old, ok := leases[addr(a)]; ok {
+		old.timer.Stop()
+	}
+
+	l := &lease{app: a}
+	l.timer = time.AfterFunc(ttl, func() {
+		s.expire(a, l)
+	})
+	leases[addr(a)] = l
+
+	s.notify(svc)
+	return nil
+}
+
+func (s *Store) renew(a app.App, ttl time.Duration) error {
+	if err := s.hook(func(h Hooks) func(app.App) error { return h.Renew }, a); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.services[service(a)][addr(a)]
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	l.timer.Reset(ttl)
+	return nil
+}
+
+func (s *Store) update(a app.App) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc := service(a)
+	l, ok := s.services[svc][addr(a)]
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	l.app = a
+
+	s.notify(svc)
+	return nil
+}
+
+func (s *Store) delete(a app.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc := service(a)
+	l, ok := s.services[svc][addr(a)]
+	if !ok {
+		return
+	}
+	l.timer.Stop()
+	delete(s.services[svc], addr(a))
+
+	s.notify(svc)
+}
+
+func (s *Store) expire(a app.App, l *lease) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc := service(a)
+	if s.services[svc][addr(a)] != l {
+		return
+	}
+	delete(s.services[svc], addr(a))
+
+	s.notify(svc)
+}
+
+// Expire removes the app at once, as if its lease had not been renewed in time
+func (s *Store) Expire(a app.App) {
+	s.mu.Lock()
+	l := s.services[service(a)][addr(a)]
+	s.mu.Unlock()
+
+	if l != nil {
+		l.timer.Stop()
+		s.expire(a, l)
+	}
+}
+
+// Apps returns the apps registered as env/name, sorted by address
+func (s *Store) Apps(env, name string) []app.App {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apps(env + "/" + name)
+}
+
+func (s *Store) apps(svc string) []app.App {
+	apps := make([]app.App, 0, len(s.services[svc]))
+	for _, l := range s.services[svc] {
+		apps = append(apps, l.app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return addr(apps[i]) < addr(apps[j])
+	})
+	return apps
+}
+
+// Watch delivers the apps registered as env/name at once and after every
+// change, only the latest list is kept for a slow receiver. The channel is
+// never closed, cancel stops the deliveries.
+func (s *Store) Watch(env, name string) (<-chan []app.App, func()) {
+	svc := env + "/" + name
+	w := &watcher{
+		ch:   make(chan []app.App, 1),
+		done: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if _, ok := s.watchers[svc]; !ok {
+		s.watchers[svc] = make(map[*watcher]struct{})
+	}
+	s.watchers[svc][w] = struct{}{}
+	w.ch <- s.apps(svc)
+	s.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.watchers[svc], w)
+			if w.pending != nil {
+				w.pending.Stop()
+			}
+			s.mu.Unlock()
+			close(w.done)
+		})
+	}
+	return w.ch, cancel
+}
+
+// notify must be called with s.mu held
+func (s *Store) notify(svc string) {
+	delay := s.hooks.NotifyDelay
+	if delay <= 0 {
+		apps := s.apps(svc)
+		for w := range s.watchers[svc] {
+			w.send(apps)
+		}
+		return
+	}
+
+	// 每个watcher只有一个延迟的通知，触发时重新读取，保证最后发送的是最新的列表
+	for w := range s.watchers[svc] {
+		if w.pending != nil {
+			continue
+		}
+		w := w
+		w.pending = time.AfterFunc(delay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			w.pending = nil
+			if _, ok := s.watchers[svc][w]; ok {
+				w.send(s.apps(svc))
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/logger"
+)
+
+// Policy decides when the registration in several registries fails
+type Policy int
+
+const (
+	// FailIfAny fails the registration as soon as one registry fails
+	FailIfAny Policy = iota
+	// FailIfAll fails the registration once every registry has failed, the
+	// failures before are logged
+	FailIfAll
+)
+
+// WithPolicy sets when the registration fails, FailIfAny by default
+func WithPolicy(policy Policy) MultiOption {
+	return func(opts *MultiOptions) {
+		opts.policy = policy
+	}
+}
+
+// WithLogger sets the logger of the failures ignored with FailIfAll
+func WithLogger(l logger.Logger) MultiOption {
+	return func(opts *MultiOptions) {
+		opts.l = l
+	}
+}
+
+type MultiOption func(opts *MultiOptions)
+type MultiOptions struct {
+	policy Policy
+	l      logger.Logger
+}
+
+type multiRegistry struct {
+	regs []Registry
+	opts *MultiOptions
+}
+
+// Multi registers the apps in all the registries, e.g. both in consul and
+// etcd during a migration, and fails as soon as one of them fails.
+func Multi(regs ...Registry) Registry {
+	return NewMulti(regs)
+}
+
+func NewMulti(regs []Registry, opts ...MultiOption) Registry {
+	m := &multiRegistry{
+		regs: regs,
+		opts: new(MultiOptions),
+	}
+
+	for _, opt := range opts {
+		opt(m.opts)
+	}
+
+	if m.opts.l == nil {
+		m.opts.l = logger.Default
+	}
+
+	return m
+}
+
+// Register returns the first error of the registries with FailIfAny, the
+// last one with FailIfAll. The other registries keep the app registered
+// until Close.
+func (m *multiRegistry) Register(a app.App) <-chan error {
+	errCh := make(chan error, 1)
+	if len(m.regs) == 0 {
+		errCh <- ErrNotSupported
+		return errCh
+	}
+
+	merged := make(chan error, len(m.regs))
+	for _, r := range m.regs {
+		go func(ch <-chan error) {
+			merged <- <-ch
+		}(r.Register(a))
+	}
+
+	go func() {
+		for failed := 1; ; failed++ {
+			err := <-merged
+			if m.opts.policy == FailIfAny || failed == len(m.regs) {
+				errCh <- err
+				return
+			}
+			if err != ErrRegistryClosed {
+				m.opts.l.Error("failed to register in one of the registries", "addr", fmt.Sprintf("%s:%d", a.Addr, a.Port), "err", err)
+			}
+		}
+	}()
+
+	return errCh
+}
+
+// Update updates the app in all the registries implementing Updater, and
+// returns the first error.
+func (m *multiRegistry) Update(a app.App) error {
+	err := ErrNotSupported
+	for _, r := range m.regs {
+		u, ok := r.(Updater)
+		if !ok {
+			continue
+		}
+		if e := u.Update(a); err == ErrNotSupported || (err == nil && e != nil) {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close closes all the registries and returns the first error
+func (m *multiRegistry) Close() error {
+	var err error
+	for _, r := range m.regs {
+		if e := r.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package registry_test
+
+import (
+	"errors"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"testing"
+	"time"
+)
+
+func TestMulti(t *testing.T) {
+	errRejected := errors.New("rejected")
+	a := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080}
+
+	for _, policy := range []registry.Policy{registry.FailIfAny, registry.FailIfAll} {
+		ok, bad := memory.NewStore(t.Name()+"-ok"), memory.NewStore(t.Name()+"-bad")
+		bad.SetHooks(memory.Hooks{Register: func(app.App) error { return errRejected }})
+
+		r := registry.NewMulti([]registry.Registry{memory.New(memory.WithStore(ok)), memory.New(memory.WithStore(bad))}, registry.WithPolicy(policy))
+		errCh := r.Register(a)
+
+		select {
+		case err := <-errCh:
+			if policy == registry.FailIfAll || err != errRejected {
+				t.Errorf("policy %d: err = %v", policy, err)
+			}
+		case <-time.After(time.Millisecond * 100):
+			if policy == registry.FailIfAny {
+				t.Errorf("policy %d: registration not failed", policy)
+			}
+		}
+		if len(ok.Apps("dev", "echo")) != 1 {
+			t.Errorf("policy %d: app not registered in the healthy registry", policy)
+		}
+
+		// 注册失败的registry返回ErrLeaseNotFound，其余的仍然更新
+		if err := registry.Drain(r, a); err != memory.ErrLeaseNotFound {
+			t.Errorf("policy %d: err = %v, want %v", policy, err, memory.ErrLeaseNotFound)
+		}
+		if apps := ok.Apps("dev", "echo"); len(apps) != 1 || !apps[0].Draining() {
+			t.Errorf("policy %d: app not drained: %v", policy, apps)
+		}
+
+		r.Close()
+		if policy == registry.FailIfAll {
+			if err := <-errCh; err != registry.ErrRegistryClosed {
+				t.Errorf("policy %d: err = %v, want %v", policy, err, registry.ErrRegistryClosed)
+			}
+		}
+		if len(ok.Apps("dev", "echo")) != 0 {
+			t.Errorf("policy %d: app not deregistered", policy)
+		}
+	}
+}
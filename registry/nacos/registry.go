@@ -0,0 +1,296 @@
+package nacos
+
+import (
+	"context"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	internalnacos "github.com/liuxp0827/grpc-lb/internal/nacos"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// WeightTag is the metadata holding the weight registered as the nacos weight
+const WeightTag = "weight"
+
+// set the namespace of the instances, ignored with WithEnvAsNamespace
+func WithNamespace(namespace string) Option {
+	return func(opts *Options) {
+		opts.namespace = namespace
+	}
+}
+
+// set the group of the instances, ignored unless WithEnvAsNamespace
+func WithGroup(group string) Option {
+	return func(opts *Options) {
+		opts.group = group
+	}
+}
+
+// WithEnvAsNamespace registers app.Env as the namespace instead of the group
+func WithEnvAsNamespace() Option {
+	return func(opts *Options) {
+		opts.envAsNamespace = true
+	}
+}
+
+func WithClientConfig(cfg constant.ClientConfig) Option {
+	return func(opts *Options) {
+		opts.clientConfig = cfg
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(opts *Options) {
+		opts.l = l
+	}
+}
+
+type Option func(opts *Options)
+type Options struct {
+	namespace      string
+	group          string
+	envAsNamespace bool
+	clientConfig   constant.ClientConfig
+	l              logger.Logger
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	apps     map[string]*app.App
+	clients  map[string]naming_client.INamingClient // namespace -> client
+	servers  []constant.ServerConfig
+	doneOnce sync.Once
+	done     chan struct{}
+	opts     *Options
+	wg       sync.WaitGroup
+}
+
+// New creates a registry of the nacos servers given as host:port, the
+// instances are ephemeral and kept alive by the heartbeats of the client.
+// app.Env is registered as the group, or the namespace with WithEnvAsNamespace.
+func New(addrs []string, opts ...Option) (registry.Registry, error) {
+	servers, err := serverConfigs(addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		apps:    make(map[string]*app.App),
+		clients: make(map[string]naming_client.INamingClient),
+		servers: servers,
+		done:    make(chan struct{}),
+		opts:    new(Options),
+	}
+
+	for _, opt := range opts {
+		opt(r.opts)
+	}
+
+	if r.opts.group == "" {
+		r.opts.group = constant.DEFAULT_GROUP
+	}
+
+	if r.opts.clientConfig.TimeoutMs == 0 {
+		r.opts.clientConfig.TimeoutMs = 5000
+		r.opts.clientConfig.NotLoadCacheAtStart = true
+	}
+
+	if r.opts.l == nil {
+		r.opts.l = logger.Default
+	}
+
+	return r, nil
+}
+
+func serverConfigs(addrs []string) ([]constant.ServerConfig, error) {
+	servers := make([]constant.ServerConfig, 0, len(addrs))
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.ParseUint(port, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, constant.ServerConfig{IpAddr: host, Port: p})
+	}
+	return servers, nil
+}
+
+// target returns the client of the namespace and the group of the app
+func (r *Registry) target(a app.App) (naming_client.INamingClient, string, error) {
+	namespace, group := r.opts.namespace, a.Env
+	if r.opts.envAsNamespace {
+		namespace, group = a.Env, r.opts.group
+	}
+	if group == "" {
+		group = constant.DEFAULT_GROUP
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[namespace]
+	if !ok {
+		cfg := r.opts.clientConfig
+		cfg.NamespaceId = namespace
+		var err error
+		client, err = internalnacos.NamingClient(cfg, r.servers)
+		if err != nil {
+			return nil, "", err
+		}
+		r.clients[namespace] = client
+	}
+	return client, group, nil
+}
+
+func weight(a app.App) float64 {
+	w, err := strconv.ParseFloat(a.Metadata[WeightTag], 64)
+	if err != nil || w <= 0 {
+		return 1
+	}
+	return w
+}
+
+func (r *Registry) Register(a app.App) <-chan error {
+	errCh := make(chan error, 1)
+
+	if dup := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+		_, dup := r.apps[addr]
+		if dup {
+			return true
+		}
+		r.apps[addr] = &a
+
+		return false
+	}(); dup {
+		errCh <- registry.ErrDupRegister
+		return errCh
+	}
+
+	select {
+	case <-r.done:
+		errCh <- registry.ErrRegistryClosed
+		return errCh
+	default:
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		attrs := tracing.Registration("nacos", a.Env+"/"+a.Name, fmt.Sprintf("%s:%d", a.Addr, a.Port))
+		_, span := tracing.Start(context.Background(), "registry.Register", attrs...)
+		client, group, err := r.target(a)
+		if err != nil {
+			tracing.End(span, err)
+			errCh <- err
+			return
+		}
+
+		// 临时实例由客户端定时发送心跳续约
+		_, err = client.RegisterInstance(vo.RegisterInstanceParam{
+			Ip:          a.Addr,
+			Port:        uint64(a.Port),
+			Weight:      weight(a),
+			Enable:      !a.Draining(),
+			Healthy:     true,
+			Metadata:    a.Metadata.ToMap(),
+			ServiceName: a.Name,
+			GroupName:   group,
+			Ephemeral:   true,
+		})
+		tracing.End(span, err)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer metrics.Registered("nacos", a.Env+"/"+a.Name)()
+		defer admin.Registered("nacos", a)()
+
+		<-r.done
+		_, span = tracing.Start(context.Background(), "registry.Deregister", attrs...)
+		_, err = client.DeregisterInstance(vo.DeregisterInstanceParam{
+			Ip:          a.Addr,
+			Port:        uint64(a.Port),
+			ServiceName: a.Name,
+			GroupName:   group,
+			Ephemeral:   true,
+		})
+		tracing.End(span, err)
+		if err != nil {
+			r.opts.l.Error("failed to deregister", "addr", fmt.Sprintf("%s:%d", a.Addr, a.Port), "err", err)
+		}
+		errCh <- registry.ErrRegistryClosed
+	}()
+
+	return errCh
+}
+
+// Update updates the weight and the metadata of the instance, a draining
+// instance is disabled.
+func (r *Registry) Update(a app.App) error {
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	r.mu.Lock()
+	old, ok := r.apps[addr]
+	if ok {
+		a.Env, a.Name = old.Env, old.Name
+		r.apps[addr] = &a
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return registry.ErrNotRegistered
+	}
+
+	client, group, err := r.target(a)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateInstance(vo.UpdateInstanceParam{
+		Ip:          a.Addr,
+		Port:        uint64(a.Port),
+		ServiceName: a.Name,
+		GroupName:   group,
+		Ephemeral:   true,
+		Weight:      weight(a),
+		Enable:      !a.Draining(),
+		Metadata:    a.Metadata.ToMap(),
+	})
+	if err != nil {
+		return err
+	}
+	admin.Updated("nacos", a)
+	return nil
+}
+
+// Close deregisters the apps and releases the naming clients, they are
+// shared with the resolvers of the same servers since the SDK can't close them
+func (r *Registry) Close() error {
+	r.doneOnce.Do(func() {
+		close(r.done)
+		r.wg.Wait()
+
+		r.mu.Lock()
+		r.clients = make(map[string]naming_client.INamingClient)
+		r.mu.Unlock()
+	})
+	return nil
+}
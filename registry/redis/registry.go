@@ -0,0 +1,272 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	goredis "github.com/go-redis/redis/v7"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ExpirySuffix is appended to the key of a service to name the sorted set of
+// the expiry timestamps of its instances, e.g. grpc-discovery/dev/echo/_expiry
+const ExpirySuffix = "/_expiry"
+
+// set prefix for key
+func WithPrefix(prefix string) Option {
+	return func(opts *Options) {
+		opts.prefix = prefix
+	}
+}
+
+func WithTTL(ttl int64) Option {
+	return func(opts *Options) {
+		opts.ttl = ttl
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(opts *Options) {
+		opts.l = l
+	}
+}
+
+type Option func(opts *Options)
+type Options struct {
+	ttl    int64
+	prefix string
+	l      logger.Logger
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	apps     map[string]*app.App
+	doneOnce sync.Once
+	done     chan struct{}
+	client   *goredis.Client
+	opts     *Options
+	wg       sync.WaitGroup
+}
+
+// New creates a registry storing the instances of a service in the hash
+// prefix/env/name (addr:port -> app.App in json), and their expiry timestamps
+// in the sorted set prefix/env/name/_expiry. Every change is published on the
+// channel prefix/env/name.
+func New(cfg *goredis.Options, opts ...Option) (registry.Registry, error) {
+	client := goredis.NewClient(cfg)
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	r := &Registry{
+		apps:   make(map[string]*app.App),
+		done:   make(chan struct{}),
+		opts:   new(Options),
+		client: client,
+	}
+
+	for _, opt := range opts {
+		opt(r.opts)
+	}
+
+	if r.opts.ttl <= 10 {
+		r.opts.ttl = 10
+	}
+
+	if r.opts.prefix == "" {
+		r.opts.prefix = "grpc-discovery"
+	}
+
+	if r.opts.l == nil {
+		r.opts.l = logger.Default
+	}
+
+	return r, nil
+}
+
+func (r *Registry) expiry() float64 {
+	return float64(time.Now().Add(time.Duration(r.opts.ttl)*time.Second).UnixNano() / int64(time.Millisecond))
+}
+
+func (r *Registry) put(key, addr string, a app.App) error {
+	_, err := r.client.TxPipelined(func(pipe goredis.Pipeliner) error {
+		pipe.HSet(key, addr, a.Encode())
+		pipe.ZAdd(key+ExpirySuffix, &goredis.Z{Score: r.expiry(), Member: addr})
+		pipe.Publish(key, addr)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	admin.Updated("redis", a)
+	return nil
+}
+
+// renew pushes back the expiry of the instance, and removes the expired
+// instances of the service
+func (r *Registry) renew(key, addr string, a app.App) error {
+	n, err := r.client.ZAddXXCh(key+ExpirySuffix, &goredis.Z{Score: r.expiry(), Member: addr}).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 { // 已经过期被删除，重新注册
+		if err := r.put(key, addr, a); err != nil {
+			return err
+		}
+	}
+
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	expired, err := r.client.ZRangeByScore(key+ExpirySuffix, &goredis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+	_, err = r.client.TxPipelined(func(pipe goredis.Pipeliner) error {
+		pipe.HDel(key, expired...)
+		for _, e := range expired {
+			pipe.ZRem(key+ExpirySuffix, e)
+		}
+		pipe.Publish(key, expired[0])
+		return nil
+	})
+	return err
+}
+
+func (r *Registry) delete(key, addr string) error {
+	_, err := r.client.TxPipelined(func(pipe goredis.Pipeliner) error {
+		pipe.HDel(key, addr)
+		pipe.ZRem(key+ExpirySuffix, addr)
+		pipe.Publish(key, addr)
+		return nil
+	})
+	return err
+}
+
+func (r *Registry) Register(a app.App) <-chan error {
+	errCh := make(chan error, 1)
+
+	if dup := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+		_, dup := r.apps[addr]
+		if dup {
+			return true
+		}
+		r.apps[addr] = &a
+
+		return false
+	}(); dup {
+		errCh <- registry.ErrDupRegister
+		return errCh
+	}
+
+	select {
+	case <-r.done:
+		errCh <- registry.ErrRegistryClosed
+		return errCh
+	default:
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		key := path.Join(r.opts.prefix, a.Env, a.Name)
+		addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+		attrs := tracing.Registration("redis", a.Env+"/"+a.Name, addr)
+		_, span := tracing.Start(context.Background(), "registry.Register", attrs...)
+		err := r.put(key, addr, a)
+		tracing.End(span, err)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer metrics.Registered("redis", a.Env+"/"+a.Name)()
+		defer admin.Registered("redis", a)()
+
+		ticker := time.NewTicker(time.Duration(r.opts.ttl*2/3) * time.Second)
+		defer ticker.Stop()
+
+		renewRetryTimes := 0
+		for {
+			select {
+			case <-r.done:
+				_, span := tracing.Start(context.Background(), "registry.Deregister", attrs...)
+				err := r.delete(key, addr)
+				tracing.End(span, err)
+				if err != nil {
+					r.opts.l.Error("failed to deregister", "addr", addr, "err", err)
+				}
+				errCh <- registry.ErrRegistryClosed
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				cur := *r.apps[addr]
+				r.mu.Unlock()
+
+				start := time.Now()
+				err := r.renew(key, addr, cur)
+				metrics.Renewed("redis", a.Env+"/"+a.Name, start, err)
+				admin.Renewed("redis", a, err)
+				if err != nil {
+					r.opts.l.Error("failed to update ttl", "addr", addr, "err", err)
+					renewRetryTimes++
+					// 如果续租失败达到一定次数，认为分区了，这时候程序应终止
+					if renewRetryTimes > registry.MaxRenewRetry {
+						errCh <- registry.ErrFailedRenew
+						return
+					}
+				} else {
+					renewRetryTimes = 0
+				}
+			}
+		}
+	}()
+
+	return errCh
+}
+
+// Update replaces the app stored in the hash and notifies the resolvers
+func (r *Registry) Update(a app.App) error {
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	r.mu.Lock()
+	old, ok := r.apps[addr]
+	if ok {
+		a.Env, a.Name = old.Env, old.Name
+		r.apps[addr] = &a
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return registry.ErrNotRegistered
+	}
+
+	key := path.Join(r.opts.prefix, a.Env, a.Name)
+	_, err := r.client.TxPipelined(func(pipe goredis.Pipeliner) error {
+		pipe.HSet(key, addr, a.Encode())
+		pipe.Publish(key, addr)
+		return nil
+	})
+	return err
+}
+
+func (r *Registry) Close() error {
+	r.doneOnce.Do(func() {
+		close(r.done)
+		r.wg.Wait()
+		// 等待所有子协程都退出才关闭client连接
+		r.client.Close()
+	})
+	return nil
+}
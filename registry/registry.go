@@ -3,11 +3,15 @@ package registry
 import (
 	"errors"
 	"github.com/liuxp0827/grpc-lb/app"
+	"google.golang.org/grpc"
+	"time"
 )
 
 var ErrDupRegister = errors.New("duplicate register")
 var ErrRegistryClosed = errors.New("has closed")
 var ErrFailedRenew = errors.New("failed renew")
+var ErrNotRegistered = errors.New("not registered")
+var ErrNotSupported = errors.New("not supported")
 
 const MaxRenewRetry = 10
 
@@ -15,3 +19,32 @@ type Registry interface {
 	Register(a app.App) <-chan error
 	Close() error
 }
+
+// Updater is implemented by registries that can update a registered app in
+// place, the app is identified by its Addr and Port.
+type Updater interface {
+	Update(a app.App) error
+}
+
+// Drain marks the app as draining, so that resolvers stop sending new rpcs to it.
+func Drain(r Registry, a app.App) error {
+	u, ok := r.(Updater)
+	if !ok {
+		return ErrNotSupported
+	}
+	a.Status = app.StatusDraining
+	return u.Update(a)
+}
+
+// GracefulStop drains the app, waits grace for the clients to see it, then
+// deregisters it and stops the server once the in-flight rpcs complete.
+// The error of the draining is returned, the server is stopped anyway.
+func GracefulStop(r Registry, s *grpc.Server, a app.App, grace time.Duration) error {
+	err := Drain(r, a)
+	if err == nil {
+		time.Sleep(grace)
+	}
+	r.Close()
+	s.GracefulStop()
+	return err
+}
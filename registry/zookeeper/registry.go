@@ -0,0 +1,317 @@
+package zookeeper
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-zookeeper/zk"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// set prefix for node path
+func WithPrefix(prefix string) Option {
+	return func(opts *Options) {
+		opts.prefix = prefix
+	}
+}
+
+func WithSessionTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.sessionTimeout = timeout
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(opts *Options) {
+		opts.l = l
+	}
+}
+
+type Option func(opts *Options)
+type Options struct {
+	sessionTimeout time.Duration
+	prefix         string
+	l              logger.Logger
+}
+
+type registration struct {
+	app    app.App
+	node   string // path of the ephemeral node, empty until created
+	errCh  chan error
+	failed chan struct{} // closed when the node could not be created again
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	apps     map[string]*registration
+	doneOnce sync.Once
+	done     chan struct{}
+	conn     *zk.Conn
+	events   <-chan zk.Event
+	opts     *Options
+	wg       sync.WaitGroup
+}
+
+// New connects to the zookeeper servers, every registered app is stored in
+// an ephemeral sequential node prefix/env/name/addr:port-<seq>, which is
+// created again when the session expires.
+func New(servers []string, opts ...Option) (registry.Registry, error) {
+	r := &Registry{
+		apps: make(map[string]*registration),
+		done: make(chan struct{}),
+		opts: new(Options),
+	}
+
+	for _, opt := range opts {
+		opt(r.opts)
+	}
+
+	if r.opts.sessionTimeout <= 0 {
+		r.opts.sessionTimeout = time.Second * 10
+	}
+
+	if r.opts.prefix == "" {
+		r.opts.prefix = "/grpc-discovery"
+	}
+
+	if r.opts.l == nil {
+		r.opts.l = logger.Default
+	}
+
+	conn, events, err := zk.Connect(servers, r.opts.sessionTimeout, zk.WithLogger(logger.Printf(r.opts.l, logger.LevelInfo)))
+	if err != nil {
+		return nil, err
+	}
+	r.conn, r.events = conn, events
+
+	r.wg.Add(1)
+	go r.watchSession()
+
+	return r, nil
+}
+
+func (r *Registry) Register(a app.App) <-chan error {
+	errCh := make(chan error, 1)
+
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+	reg := &registration{app: a, errCh: errCh, failed: make(chan struct{})}
+
+	if dup := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		_, dup := r.apps[addr]
+		if dup {
+			return true
+		}
+		r.apps[addr] = reg
+
+		return false
+	}(); dup {
+		errCh <- registry.ErrDupRegister
+		return errCh
+	}
+
+	select {
+	case <-r.done:
+		errCh <- registry.ErrRegistryClosed
+		return errCh
+	default:
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		_, span := tracing.Start(context.Background(), "registry.Register",
+			tracing.Registration("zookeeper", a.Env+"/"+a.Name, addr)...)
+		node, err := r.createNode(a)
+		tracing.End(span, err)
+		if err != nil {
+			errCh <- err
+			return
+		}
+
+		r.mu.Lock()
+		reg.node = node
+		r.mu.Unlock()
+		defer metrics.Registered("zookeeper", a.Env+"/"+a.Name)()
+		defer admin.Registered("zookeeper", a)()
+
+		select {
+		case <-r.done:
+			errCh <- registry.ErrRegistryClosed
+		case <-reg.failed:
+			errCh <- registry.ErrFailedRenew
+		}
+	}()
+
+	return errCh
+}
+
+// createNode creates the ephemeral node of the app, and returns its path
+func (r *Registry) createNode(a app.App) (string, error) {
+	dir := path.Join(r.opts.prefix, a.Env, a.Name)
+	if err := r.mkdirs(dir); err != nil {
+		return "", err
+	}
+
+	return r.conn.Create(path.Join(dir, fmt.Sprintf("%s:%d-", a.Addr, a.Port)),
+		[]byte(a.Encode()), zk.FlagEphemeral|zk.FlagSequence, zk.WorldACL(zk.PermAll))
+}
+
+// mkdirs creates the persistent parents of the ephemeral nodes
+func (r *Registry) mkdirs(dir string) error {
+	p := ""
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		p += "/" + part
+		_, err := r.conn.Create(p, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchSession creates the nodes again once a new session is established
+// after the previous one expired, since the ephemeral nodes were removed
+// along with it.
+func (r *Registry) watchSession() {
+	defer r.wg.Done()
+
+	expired := false
+	for {
+		select {
+		case <-r.done:
+			return
+		case ev, ok := <-r.events:
+			if !ok {
+				return
+			}
+			switch ev.State {
+			case zk.StateExpired:
+				r.opts.l.Warn("zookeeper session expired, will register again")
+				expired = true
+			case zk.StateHasSession:
+				if expired {
+					expired = false
+					r.recreate()
+				}
+			}
+		}
+	}
+}
+
+func (r *Registry) recreate() {
+	// 重试期间不持有锁，避免阻塞Register、Update和Close
+	r.mu.Lock()
+	regs := make(map[string]*registration, len(r.apps))
+	apps := make(map[string]app.App, len(r.apps))
+	for addr, reg := range r.apps {
+		if reg.node != "" {
+			regs[addr], apps[addr] = reg, reg.app
+		}
+	}
+	r.mu.Unlock()
+
+	for addr, reg := range regs {
+		var (
+			node string
+			err  error
+		)
+		for retryTimes := 0; retryTimes <= registry.MaxRenewRetry; retryTimes++ {
+			if node, err = r.createNode(apps[addr]); err == nil {
+				break
+			}
+			r.opts.l.Error("failed to register again", "addr", addr, "err", err)
+			select {
+			case <-r.done:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		select {
+		case <-r.done:
+			// 重试期间注册中心已关闭，Close不会再删除这个节点
+			if err == nil {
+				r.conn.Delete(node, -1)
+			}
+			return
+		default:
+		}
+
+		r.mu.Lock()
+		if err == nil {
+			reg.node = node
+		}
+		admin.Renewed("zookeeper", reg.app, err)
+		if err != nil {
+			// 多次重新注册失败，认为分区了，这时候程序应终止，由Register的协程返回ErrFailedRenew
+			delete(r.apps, addr)
+			close(reg.failed)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Update sets the data of the node the app was registered with
+func (r *Registry) Update(a app.App) error {
+	addr := fmt.Sprintf("%s:%d", a.Addr, a.Port)
+
+	r.mu.Lock()
+	reg, ok := r.apps[addr]
+	node := ""
+	if ok {
+		a.Env, a.Name, node = reg.app.Env, reg.app.Name, reg.node
+	}
+	r.mu.Unlock()
+
+	if node == "" {
+		return registry.ErrNotRegistered
+	}
+
+	if _, err := r.conn.Set(node, []byte(a.Encode()), -1); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	reg.app = a
+	r.mu.Unlock()
+	admin.Updated("zookeeper", a)
+	return nil
+}
+
+func (r *Registry) Close() error {
+	r.doneOnce.Do(func() {
+		// 删除节点时不持有锁
+		r.mu.Lock()
+		regs := make([]registration, 0, len(r.apps))
+		for _, reg := range r.apps {
+			if reg.node != "" {
+				regs = append(regs, *reg)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, reg := range regs {
+			_, span := tracing.Start(context.Background(), "registry.Deregister",
+				tracing.Registration("zookeeper", reg.app.Env+"/"+reg.app.Name, fmt.Sprintf("%s:%d", reg.app.Addr, reg.app.Port))...)
+			tracing.End(span, r.conn.Delete(reg.node, -1))
+		}
+
+		close(r.done)
+		r.wg.Wait()
+		// 等待所有子协程都退出才关闭连接
+		r.conn.Close()
+	})
+	return nil
+}
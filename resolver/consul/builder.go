@@ -2,29 +2,51 @@ package consul
 
 import (
 	"github.com/hashicorp/consul/api"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
 	"github.com/liuxp0827/grpc-lb/internal/backoff"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
 	"google.golang.org/grpc/resolver"
+	"strings"
 	"time"
 )
 
+// ConfigKey is the key under ConfigPrefix/<service> holding the service config
+const ConfigKey = "_config"
+
 var (
-	DataCenter      = "dc1"
+	ConfigPrefix = "grpc-discovery"
+	DataCenter   = "dc1"
+	// DataCenters are the datacenters to fail over to in order when the
+	// previous ones have no passing instance, DataCenter only if empty
+	DataCenters     []string
 	BackoffMaxDelay = time.Second * 1
+	// Logger is used by the consul resolvers, logger.Default if nil
+	Logger logger.Logger
 )
 
 func init() {
-	resolver.Register(&consulBuilder{
-	})
+	resolver.Register(admin.Builder(metrics.Builder(&consulBuilder{
+	})))
 }
 
 type consulBuilder struct {
 	addresses chan []resolver.Address
 }
 
+// consul://127.0.0.1:8500/dev/echo, or consul://127.0.0.1:8500/dev/echo?dc=dc1,dc2
+// to override DataCenters
 func (b *consulBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	dc := DataCenter
+	key, dcs := target.Endpoint, DataCenters
+	if i := strings.Index(key, "?dc="); i >= 0 {
+		key, dcs = key[:i], strings.Split(key[i+len("?dc="):], ",")
+	}
+	if len(dcs) == 0 {
+		dcs = []string{DataCenter}
+	}
+
 	client, err := api.NewClient(&api.Config{
-		Datacenter: dc,
+		Datacenter: dcs[0],
 		Address:    target.Authority,
 		Scheme:     "http",
 	})
@@ -32,16 +54,28 @@ func (b *consulBuilder) Build(target resolver.Target, cc resolver.ClientConn, op
 		return nil, err
 	}
 
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
 	r := &consulResolver{
-		cc:      cc,
-		client:  client,
-		dc:      dc,
-		key:     target.Endpoint,
-		done:    make(chan struct{}),
-		backoff: backoff.New(BackoffMaxDelay).Backoff,
+		cc:       cc,
+		client:   client,
+		dcs:      dcs,
+		key:      key,
+		target:   target.Endpoint,
+		done:     make(chan struct{}),
+		backoff:  metrics.Backoff(b.Scheme(), target.Endpoint, backoff.New(BackoffMaxDelay).Backoff),
+		entries:  make([][]*api.ServiceEntry, len(dcs)),
+		resolved: make([]bool, len(dcs)),
+		logger:   logger.With(l, "scheme", b.Scheme(), "target", target.Endpoint),
 	}
 
-	go r.watch()
+	for i := range dcs {
+		go r.watch(i)
+	}
+	go r.watchConfig()
 
 	return r, nil
 }
@@ -1,71 +1,238 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 	"github.com/hashicorp/consul/api"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc/resolver"
-	"log"
+	"path"
 	"sync"
 	"time"
 )
 
+// metadata added to the addresses, to tell the instances of the local
+// datacenter (the first one) from the ones of the datacenters failed over to
+const (
+	DataCenterTag  = "dc"
+	LocalityTag    = "locality"
+	LocalityLocal  = "local"
+	LocalityRemote = "remote"
+)
+
 type consulResolver struct {
 	cc       resolver.ClientConn
 	client   *api.Client
-	dc       string // DataCenter
-	key      string // ServiceName
+	dcs      []string // DataCenters
+	key      string   // ServiceName
+	target   string
 	done     chan struct{}
 	doneOnce sync.Once
 	backoff  func(int) time.Duration
+	logger   logger.Logger
+
+	mu            sync.Mutex
+	entries       [][]*api.ServiceEntry // 每个数据中心的实例
+	resolved      []bool
+	config        string                         // service config in json
+	metadata      map[string]interface{}         // 上一次下发的metadata，见ReuseMetadata
+	serviceConfig internalresolver.ServiceConfig // 最后一次有效的服务配置
 }
 
-func (r *consulResolver) watch() {
+func (r *consulResolver) watch(i int) {
 	qo := &api.QueryOptions{
-		Datacenter: r.dc,
+		Datacenter: r.dcs[i],
 		WaitTime:   time.Second * 10,
 	}
 
 	retryTimes := 0
 
 	for {
+		// 第一次查询为list，之后的阻塞查询为watch
+		name := "resolver.Watch"
+		if qo.WaitIndex == 0 {
+			name = "resolver.List"
+		}
+		_, span := tracing.Start(context.Background(), name,
+			append(tracing.Resolution("consul", r.target), attribute.String("grpc_lb.dc", r.dcs[i]))...)
 		addrs, qm, err := r.client.Health().Service(r.key, "", false, qo)
 		if err != nil {
-			log.Printf("[error]failed to resolve addr, caused by %s", err)
+			tracing.Resolved(span, 0, err)
+			r.logger.Error("failed to resolve addr", "dc", r.dcs[i], "err", err)
 			delay := r.backoff(retryTimes)
 			retryTimes++
-			time.Sleep(delay)
+			select {
+			case <-r.done:
+				return
+			case <-time.After(delay):
+			}
 			continue
 		}
 
 		if r.hasClosed() {
+			span.End()
 			break
 		}
 
 		qo.WaitIndex = qm.LastIndex
 
-		addresses := make([]resolver.Address, len(addrs))
+		r.mu.Lock()
+		r.entries[i], r.resolved[i] = addrs, true
+		r.update()
+		r.mu.Unlock()
+		tracing.Resolved(span, len(addrs), nil)
 
-		for i := range addrs {
-			svc := addrs[i].Service
-			addresses[i] = resolver.Address{
-				Addr:       fmt.Sprintf("%s:%d", svc.Address, svc.Port),
-				ServerName: svc.Service,
-			}
-			addresses[i].Metadata = &svc.Meta
+		if r.hasClosed() {
+			break
 		}
 
-		r.cc.UpdateState(resolver.State{
-			Addresses: addresses,
-		})
+		retryTimes = 0
+	}
+}
+
+// watchConfig watches the service config of the service in the kv store,
+// e.g. grpc-discovery/dev/echo/_config
+func (r *consulResolver) watchConfig() {
+	key := path.Join(ConfigPrefix, r.key, ConfigKey)
+	qo := &api.QueryOptions{
+		Datacenter: r.dcs[0],
+		WaitTime:   time.Second * 10,
+	}
+
+	retryTimes := 0
+
+	for {
+		pair, qm, err := r.client.KV().Get(key, qo)
+		if err != nil {
+			r.logger.Error("failed to get service config", "err", err)
+			delay := r.backoff(retryTimes)
+			retryTimes++
+			time.Sleep(delay)
+			if r.hasClosed() {
+				break
+			}
+			continue
+		}
 
 		if r.hasClosed() {
 			break
 		}
 
+		qo.WaitIndex = qm.LastIndex
+
+		config := ""
+		if pair != nil {
+			config = string(pair.Value)
+		}
+
+		r.mu.Lock()
+		if config != r.config {
+			r.config = config
+			r.update()
+		}
+		r.mu.Unlock()
+
 		retryTimes = 0
 	}
 }
 
+// update must be called with r.mu held, it uses the instances of the first
+// datacenter with a passing instance, or the local ones if there is none
+func (r *consulResolver) update() {
+	i := -1
+	for j := range r.dcs {
+		if !r.resolved[j] {
+			break
+		}
+		if passing(r.entries[j]) {
+			i = j
+			break
+		}
+	}
+	critical := i < 0
+	if critical {
+		// 地址还未解析时不更新，避免下发空的地址列表
+		if !r.resolved[0] {
+			return
+		}
+		// 所有数据中心都没有健康的实例时，使用本地的全部实例
+		i = 0
+	}
+
+	locality := LocalityLocal
+	if i > 0 {
+		locality = LocalityRemote
+	}
+	addresses := services2Addrs(r.entries[i], critical)
+
+	for j := range addresses {
+		addr := &addresses[j]
+		md := map[string]string{DataCenterTag: r.dcs[i], LocalityTag: locality}
+		for k, v := range *addr.Metadata.(*map[string]string) {
+			md[k] = v
+		}
+		addr.Metadata = &md
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addresses)
+
+	state := resolver.State{
+		Addresses: addresses,
+	}
+	sc, err := r.serviceConfig.Parse(r.cc, r.config)
+	if err != nil {
+		// 无效的配置不下发，继续使用上一次有效的配置
+		r.logger.Error("failed to parse service config", "err", err)
+	}
+	state.ServiceConfig = sc
+	r.cc.UpdateState(state)
+}
+
+// services2Addrs converts the instances passing all their checks, or all of
+// them if critical is true. Draining instances are always skipped.
+func services2Addrs(entries []*api.ServiceEntry, critical bool) []resolver.Address {
+	addresses := make([]resolver.Address, 0, len(entries))
+
+	for i := range entries {
+		svc := entries[i].Service
+		if draining(svc.Tags) { // 摘流中的实例不再接收新的请求
+			continue
+		}
+		if !critical && entries[i].Checks.AggregatedStatus() != api.HealthPassing {
+			continue
+		}
+		addr := resolver.Address{
+			Addr:       fmt.Sprintf("%s:%d", svc.Address, svc.Port),
+			ServerName: svc.Service,
+		}
+		addr.Metadata = &svc.Meta
+		addresses = append(addresses, addr)
+	}
+	return addresses
+}
+
+// passing tells whether an instance not draining passes all its checks
+func passing(entries []*api.ServiceEntry) bool {
+	for _, e := range entries {
+		if !draining(e.Service.Tags) && e.Checks.AggregatedStatus() == api.HealthPassing {
+			return true
+		}
+	}
+	return false
+}
+
+func draining(tags []string) bool {
+	for _, tag := range tags {
+		if tag == app.StatusDraining {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *consulResolver) hasClosed() bool {
 	select {
 	case <-r.done:
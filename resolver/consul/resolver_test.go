@@ -0,0 +1,128 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"testing"
+	"time"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+	state *resolver.State
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) {
+	cc.state = &s
+}
+
+func entry(addr, status string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Service: &api.AgentService{Service: "dev/echo", Address: addr, Port: 8080, Meta: map[string]string{"weight": "100"}},
+		Checks:  api.HealthChecks{{Status: status}},
+	}
+}
+
+func TestFailover(t *testing.T) {
+	cc := &testClientConn{}
+	r := &consulResolver{
+		cc:       cc,
+		dcs:      []string{"dc1", "dc2"},
+		key:      "dev/echo",
+		entries:  make([][]*api.ServiceEntry, 2),
+		resolved: make([]bool, 2),
+	}
+
+	check := func(addr, dc, locality string) {
+		t.Helper()
+		if cc.state == nil || len(cc.state.Addresses) != 1 {
+			t.Fatalf("state = %v, want %s", cc.state, addr)
+		}
+		a := cc.state.Addresses[0]
+		md := *a.Metadata.(*map[string]string)
+		if a.Addr != addr || md[DataCenterTag] != dc || md[LocalityTag] != locality || md["weight"] != "100" {
+			t.Errorf("got %s %v, want %s in %s (%s)", a.Addr, md, addr, dc, locality)
+		}
+	}
+
+	// 本地数据中心没有健康的实例，等待下一个数据中心的结果
+	r.entries[0], r.resolved[0] = []*api.ServiceEntry{entry("10.0.1.1", api.HealthCritical)}, true
+	r.update()
+	check("10.0.1.1:8080", "dc1", LocalityLocal)
+
+	r.entries[1], r.resolved[1] = []*api.ServiceEntry{entry("10.0.2.1", api.HealthPassing)}, true
+	r.update()
+	check("10.0.2.1:8080", "dc2", LocalityRemote)
+
+	// 本地恢复后切回本地
+	r.entries[0] = []*api.ServiceEntry{entry("10.0.1.1", api.HealthPassing)}
+	r.update()
+	check("10.0.1.1:8080", "dc1", LocalityLocal)
+}
+
+func TestServices2Addrs(t *testing.T) {
+	entries := []*api.ServiceEntry{entry("10.0.1.1", api.HealthCritical), entry("10.0.1.2", api.HealthPassing)}
+
+	addrs := services2Addrs(entries, false)
+	if len(addrs) != 1 || addrs[0].Addr != "10.0.1.2:8080" {
+		t.Errorf("addrs = %v, want only the passing 10.0.1.2:8080", addrs)
+	}
+
+	if addrs := services2Addrs(entries, true); len(addrs) != 2 {
+		t.Errorf("addrs = %v, want both instances", addrs)
+	}
+}
+
+func TestPassingOnly(t *testing.T) {
+	cc := &testClientConn{}
+	r := &consulResolver{
+		cc:       cc,
+		dcs:      []string{"dc1"},
+		key:      "dev/echo",
+		entries:  make([][]*api.ServiceEntry, 1),
+		resolved: make([]bool, 1),
+	}
+
+	// 有健康的实例时只下发健康的实例
+	r.entries[0], r.resolved[0] = []*api.ServiceEntry{
+		entry("10.0.1.1", api.HealthPassing),
+		entry("10.0.1.2", api.HealthCritical),
+		entry("10.0.1.3", api.HealthCritical),
+	}, true
+	r.update()
+	if len(cc.state.Addresses) != 1 || cc.state.Addresses[0].Addr != "10.0.1.1:8080" {
+		t.Errorf("addresses = %v, want only 10.0.1.1:8080", cc.state.Addresses)
+	}
+}
+
+func TestCloseWhileFailing(t *testing.T) {
+	client, err := api.NewClient(&api.Config{Address: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &consulResolver{
+		cc:       &testClientConn{},
+		client:   client,
+		dcs:      []string{"dc1"},
+		done:     make(chan struct{}),
+		backoff:  func(int) time.Duration { return time.Hour },
+		logger:   logger.Default,
+		entries:  make([][]*api.ServiceEntry, 1),
+		resolved: make([]bool, 1),
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		r.watch(0)
+		close(exited)
+	}()
+	time.Sleep(time.Millisecond * 100)
+	r.Close()
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second * 5):
+		t.Fatal("watch did not exit after Close")
+	}
+}
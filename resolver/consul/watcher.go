@@ -1,10 +1,10 @@
 package consul
 
 import (
-	"fmt"
 	"github.com/hashicorp/consul/api"
 	"github.com/liuxp0827/grpc-lb/internal/backoff"
-	"github.com/liuxp0827/grpc-lb/internal/logger"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
 	"google.golang.org/grpc/resolver"
 	"sync"
 	"time"
@@ -33,15 +33,20 @@ func NewWatcher(addr, srvName string) (*Watcher, error) {
 		return nil, err
 	}
 
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
 	watcher := &Watcher{
 		addresses: make(chan []resolver.Address, 0),
 		client:    client,
 		dc:        dc,
 		key:       srvName,
 		done:      make(chan struct{}),
-		backoff:   backoff.New(BackoffMaxDelay).Backoff,
+		backoff:   metrics.Backoff("consul", srvName, backoff.New(BackoffMaxDelay).Backoff),
 		doneOnce:  sync.Once{},
-		logger:    logger.DefaultLogger,
+		logger:    logger.With(l, "scheme", "consul", "target", srvName),
 	}
 
 	go watcher.watch()
@@ -60,7 +65,7 @@ func (w *Watcher) watch() {
 	for {
 		addrs, qm, err := w.client.Health().Service(w.key, "", false, qo)
 		if err != nil {
-			w.logger.Printf("[error]failed to resolve addr, caused by %s", err)
+			w.logger.Error("failed to resolve addr", "err", err)
 			delay := w.backoff(retryTimes)
 			retryTimes++
 			time.Sleep(delay)
@@ -73,16 +78,7 @@ func (w *Watcher) watch() {
 
 		qo.WaitIndex = qm.LastIndex
 
-		addresses := make([]resolver.Address, len(addrs))
-
-		for i := range addrs {
-			svc := addrs[i].Service
-			addresses[i] = resolver.Address{
-				Addr:       fmt.Sprintf("%s:%d", svc.Address, svc.Port),
-				ServerName: svc.Service,
-			}
-			addresses[i].Metadata = &svc.Meta
-		}
+		addresses := services2Addrs(addrs, false)
 
 		if w.hasClosed() {
 			break
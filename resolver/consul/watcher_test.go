@@ -2,20 +2,30 @@ package consul
 
 import (
 	"log"
+	"os"
 	"testing"
+	"time"
 )
 
+// TestWatcher needs a consul agent, given as CONSUL_ADDR=127.0.0.1:8500
 func TestWatcher(t *testing.T) {
-	watcher, err := NewWatcher("192.168.1.75:8500", "echo")
+	addr := os.Getenv("CONSUL_ADDR")
+	if addr == "" {
+		t.Skip("CONSUL_ADDR not set")
+	}
+
+	watcher, err := NewWatcher(addr, "echo")
 	if err != nil {
 		t.Fatal(err)
 	}
-	for {
-		select {
-		case addr := <-watcher.Watch():
-			for _, a := range addr {
-				log.Printf("address: %v", a)
-			}
+	defer watcher.Close()
+
+	select {
+	case addr := <-watcher.Watch():
+		for _, a := range addr {
+			log.Printf("address: %v", a)
 		}
+	case <-time.After(time.Second * 30):
+		t.Fatal("timeout waiting for the addresses")
 	}
 }
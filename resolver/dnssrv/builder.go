@@ -0,0 +1,73 @@
+package dnssrv
+
+import (
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/backoff"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/resolver"
+	"net"
+	"time"
+)
+
+var (
+	// ResolvConf provides the name servers when the target has no authority
+	ResolvConf      = "/etc/resolv.conf"
+	MinTTL          = time.Second * 5
+	MaxTTL          = time.Minute * 5
+	BackoffMaxDelay = time.Second * 10
+	// Logger is used by the DNS SRV resolvers, logger.Default if nil
+	Logger logger.Logger
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&srvBuilder{})))
+}
+
+type srvBuilder struct{}
+
+// dnssrv:///_grpc._tcp.echo.example.com or, with a name server,
+// dnssrv://10.0.0.2:53/_grpc._tcp.echo.example.com
+func (b *srvBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	var servers []string
+	if target.Authority != "" {
+		server := target.Authority
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		servers = []string{server}
+	} else {
+		conf, err := dns.ClientConfigFromFile(ResolvConf)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range conf.Servers {
+			servers = append(servers, net.JoinHostPort(s, conf.Port))
+		}
+	}
+
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
+	r := &srvResolver{
+		cc:      cc,
+		name:    dns.Fqdn(target.Endpoint),
+		servers: servers,
+		client:  &dns.Client{Timeout: time.Second * 3},
+		done:    make(chan struct{}),
+		now:     make(chan struct{}, 1),
+		backoff: metrics.Backoff(b.Scheme(), target.Endpoint, backoff.New(BackoffMaxDelay).Backoff),
+		logger:  logger.With(l, "scheme", b.Scheme(), "target", target.Endpoint),
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *srvBuilder) Scheme() string {
+	return "dnssrv"
+}
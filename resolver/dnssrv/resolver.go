@@ -0,0 +1,265 @@
+package dnssrv
+
+import (
+	"context"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/resolver"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WeightTag   = "weight"
+	PriorityTag = "priority"
+)
+
+type srvResolver struct {
+	cc       resolver.ClientConn
+	name     string
+	servers  []string
+	client   *dns.Client
+	done     chan struct{}
+	doneOnce sync.Once
+	now      chan struct{}
+	backoff  func(int) time.Duration
+	logger   logger.Logger
+
+	metadata map[string]interface{} // 上一次下发的metadata，见ReuseMetadata
+}
+
+// watch resolves the name again when the records expire, or on ResolveNow
+// but at most once every MinTTL
+func (r *srvResolver) watch() {
+	retryTimes := 0
+
+	for {
+		var wait time.Duration
+		_, span := tracing.Start(context.Background(), "resolver.List", tracing.Resolution("dnssrv", r.name)...)
+		addrs, ttl, err := r.resolve()
+		tracing.Resolved(span, len(addrs), err)
+		if err != nil {
+			r.logger.Error("failed to resolve addr", "err", err)
+			wait = r.backoff(retryTimes)
+			retryTimes++
+		} else {
+			retryTimes = 0
+			r.cc.UpdateState(resolver.State{
+				Addresses: addrs,
+			})
+			wait = ttl
+		}
+		resolved := time.Now()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-r.now:
+			timer.Stop()
+			if d := MinTTL - time.Since(resolved); d > 0 {
+				select {
+				case <-r.done:
+					return
+				case <-time.After(d):
+				}
+			}
+		}
+	}
+}
+
+// resolve returns the addresses of the lowest priority with any, the other
+// priorities are not used while it has one, even an unhealthy one
+func (r *srvResolver) resolve() ([]resolver.Address, time.Duration, error) {
+	msg, err := r.query(r.name, dns.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ttl := MaxTTL
+	minTTL := func(rr dns.RR) {
+		if d := time.Duration(rr.Header().Ttl) * time.Second; d < ttl {
+			ttl = d
+		}
+	}
+
+	// 按priority分组，从小到大依次尝试
+	groups := make(map[uint16][]*dns.SRV)
+	var priorities []int
+	for _, rr := range msg.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		minTTL(rr)
+		if _, ok := groups[srv.Priority]; !ok {
+			priorities = append(priorities, int(srv.Priority))
+		}
+		groups[srv.Priority] = append(groups[srv.Priority], srv)
+	}
+	sort.Ints(priorities)
+
+	// 使用第一组至少有一个地址的记录，解析失败的目标主机被跳过
+	var lastErr error
+	addrs := make([]resolver.Address, 0)
+	for _, priority := range priorities {
+		seen := make(map[string]bool)
+		for _, srv := range groups[uint16(priority)] {
+			ips, err := r.lookupHost(srv.Target, msg.Extra, minTTL)
+			if err != nil {
+				r.logger.Error("failed to resolve target", "host", srv.Target, "err", err)
+				lastErr = err
+				continue
+			}
+
+			md, err := r.lookupMetadata(srv.Target, minTTL)
+			if err != nil {
+				r.logger.Error("failed to resolve metadata of target", "host", srv.Target, "err", err)
+				lastErr = err
+				continue
+			}
+			// weight为0的记录被选中的概率很小，按最小的权重1处理
+			if srv.Weight > 0 {
+				md[WeightTag] = strconv.Itoa(int(srv.Weight))
+			} else if md[WeightTag] == "" {
+				md[WeightTag] = "1"
+			}
+			md[PriorityTag] = strconv.Itoa(int(srv.Priority))
+
+			for _, ip := range ips {
+				key := net.JoinHostPort(ip, strconv.Itoa(int(srv.Port)))
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				addrs = append(addrs, resolver.Address{
+					Addr:       key,
+					ServerName: strings.TrimSuffix(srv.Target, "."),
+					Metadata:   &md,
+				})
+			}
+		}
+		if len(addrs) > 0 {
+			break
+		}
+	}
+	if len(addrs) == 0 && lastErr != nil {
+		return nil, 0, lastErr
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
+
+	if ttl < MinTTL {
+		ttl = MinTTL
+	}
+	return addrs, ttl, nil
+}
+
+// lookupHost uses the addresses in the additional section of the srv
+// response if present
+func (r *srvResolver) lookupHost(host string, extra []dns.RR, minTTL func(dns.RR)) ([]string, error) {
+	var ips []string
+	collect := func(rrs []dns.RR) {
+		for _, rr := range rrs {
+			if !strings.EqualFold(rr.Header().Name, host) {
+				continue
+			}
+			switch v := rr.(type) {
+			case *dns.A:
+				ips = append(ips, v.A.String())
+			case *dns.AAAA:
+				ips = append(ips, v.AAAA.String())
+			default:
+				continue
+			}
+			minTTL(rr)
+		}
+	}
+
+	collect(extra)
+	if len(ips) > 0 {
+		return ips, nil
+	}
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg, err := r.query(host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		collect(msg.Answer)
+	}
+	return ips, nil
+}
+
+// lookupMetadata parses the txt records of the host as key=value pairs
+func (r *srvResolver) lookupMetadata(host string, minTTL func(dns.RR)) (app.Metadata, error) {
+	msg, err := r.query(host, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	md := app.Metadata{}
+	for _, rr := range msg.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		minTTL(rr)
+		for _, s := range txt.Txt {
+			if i := strings.Index(s, "="); i > 0 {
+				md[s[:i]] = s[i+1:]
+			}
+		}
+	}
+	return md, nil
+}
+
+func (r *srvResolver) query(name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+
+	var err error
+	for _, server := range r.servers {
+		var in *dns.Msg
+		in, _, err = r.client.Exchange(m, server)
+		if err == nil && in.Truncated {
+			tcp := &dns.Client{Net: "tcp", Timeout: r.client.Timeout}
+			in, _, err = tcp.Exchange(m, server)
+		}
+		if err != nil {
+			continue
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			err = fmt.Errorf("dnssrv: failed to query %s %s: %s", name, dns.TypeToString[qtype], dns.RcodeToString[in.Rcode])
+			continue
+		}
+		return in, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("dnssrv: no name server to query %s", name)
+	}
+	return nil, err
+}
+
+func (r *srvResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *srvResolver) Close() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
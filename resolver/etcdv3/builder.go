@@ -1,7 +1,10 @@
 package etcdv3
 
 import (
+	"github.com/liuxp0827/grpc-lb/internal/admin"
 	"github.com/liuxp0827/grpc-lb/internal/backoff"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
 	"go.etcd.io/etcd/clientv3"
 	"google.golang.org/grpc/resolver"
 	"path"
@@ -9,14 +12,20 @@ import (
 	"time"
 )
 
+// ConfigKey is the key under the service path holding its service config,
+// e.g. /grpc-discovery/dev/echo/_config
+const ConfigKey = "_config"
+
 var (
 	PathPrefix      = "/grpc-discovery"
 	BackoffMaxDelay = time.Second * 1
 	DialTimeout     = time.Second * 5
+	// Logger is used by the etcd resolvers, logger.Default if nil
+	Logger logger.Logger
 )
 
 func init() {
-	resolver.Register(&etcdBuilder{})
+	resolver.Register(admin.Builder(metrics.Builder(&etcdBuilder{})))
 }
 
 type etcdBuilder struct{}
@@ -24,11 +33,18 @@ type etcdBuilder struct{}
 // etcd://192.168.50.10:2379,192.168.50.11:2379,192.168.50.12:2379/dev/echo
 func (b *etcdBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
 	key := path.Join(PathPrefix, target.Endpoint)
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
 	r := &etcdResolver{
 		cc:      cc,
 		key:     key,
+		target:  target.Endpoint,
 		done:    make(chan struct{}),
-		backoff: backoff.New(BackoffMaxDelay).Backoff,
+		backoff: metrics.Backoff(b.Scheme(), target.Endpoint, backoff.New(BackoffMaxDelay).Backoff),
+		logger:  logger.With(l, "scheme", b.Scheme(), "target", target.Endpoint),
 	}
 
 	client, err := clientv3.New(clientv3.Config{
@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
 	"go.etcd.io/etcd/clientv3"
 	"google.golang.org/grpc/resolver"
-	"log"
+	"path"
 	"sync"
 	"time"
 )
@@ -17,7 +21,13 @@ type etcdResolver struct {
 	cc       resolver.ClientConn
 	client   *clientv3.Client
 	key      string
+	target   string
 	backoff  func(int) time.Duration
+	logger   logger.Logger
+	config   string // service config in json
+
+	metadata      map[string]interface{}         // 上一次下发的metadata，见ReuseMetadata
+	serviceConfig internalresolver.ServiceConfig // 最后一次有效的服务配置
 }
 
 func (r *etcdResolver) ResolveNow(resolver.ResolveNowOptions) {}
@@ -42,11 +52,13 @@ func (r *etcdResolver) watch() {
 	)
 
 	for {
-		cctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		ctx, span := tracing.Start(context.Background(), "resolver.List", tracing.Resolution("etcd", r.target)...)
+		cctx, cancel := context.WithTimeout(ctx, time.Second*3)
 		resp, err := r.client.Get(cctx, r.key, clientv3.WithPrefix())
 		cancel()
 		if err != nil {
-			log.Printf("[error]failed to resolve addr, caused by %s", err)
+			tracing.Resolved(span, 0, err)
+			r.logger.Error("failed to resolve addr", "err", err)
 			delay := r.backoff(retryTimes)
 			retryTimes++
 			time.Sleep(delay)
@@ -57,14 +69,16 @@ func (r *etcdResolver) watch() {
 		rev = resp.Header.Revision
 
 		for _, kv := range resp.Kvs {
+			if r.isConfig(kv.Key) {
+				r.config = string(kv.Value)
+				continue
+			}
 			a := app.App{}
 			a.Decode(kv.Value)
 			apps[string(kv.Key)] = &a
 		}
-		addrs := r.insts2Addrs(apps)
-		r.cc.UpdateState(resolver.State{
-			Addresses: addrs,
-		})
+		r.update(apps)
+		tracing.Resolved(span, len(apps), nil)
 
 		break
 	}
@@ -84,7 +98,7 @@ func (r *etcdResolver) watch() {
 
 		case event := <-watchCh:
 			if event.Canceled {
-				log.Printf("failed to watch server addresses changed, caused by: %v", event.Err())
+				r.logger.Warn("failed to watch server addresses changed", "err", event.Err())
 				cancel()
 				if r.hasClosed() {
 					return
@@ -92,15 +106,23 @@ func (r *etcdResolver) watch() {
 					delay := r.backoff(retryTimes)
 					retryTimes++
 					time.Sleep(delay)
+					metrics.WatchRestarted("etcd", r.target)
 					cctx, cancel = context.WithCancel(context.Background())
 					watchCh = r.client.Watch(cctx, r.key, clientv3.WithPrefix(), clientv3.WithProgressNotify(), clientv3.WithRev(rev+1))
 				}
 				continue
 			}
 
+			_, span := tracing.Start(context.Background(), "resolver.Watch", tracing.Resolution("etcd", r.target)...)
 			for _, ev := range event.Events {
 				key := string(ev.Kv.Key)
-				ev.IsCreate()
+				if r.isConfig(ev.Kv.Key) {
+					r.config = ""
+					if ev.Type == clientv3.EventTypePut {
+						r.config = string(ev.Kv.Value)
+					}
+					continue
+				}
 				switch ev.Type {
 				case clientv3.EventTypePut:
 					a := app.App{}
@@ -110,22 +132,40 @@ func (r *etcdResolver) watch() {
 					delete(apps, key)
 				}
 			}
-		}
 
-		if retryTimes > 0 {
-			retryTimes = 0
+			if retryTimes > 0 {
+				retryTimes = 0
+			}
+
+			r.update(apps)
+			tracing.Resolved(span, len(apps), nil)
 		}
+	}
+}
+
+func (r *etcdResolver) isConfig(key []byte) bool {
+	return string(key) == path.Join(r.key, ConfigKey)
+}
 
-		addrs := r.insts2Addrs(apps)
-		r.cc.UpdateState(resolver.State{
-			Addresses: addrs,
-		})
+func (r *etcdResolver) update(apps map[string]*app.App) {
+	state := resolver.State{
+		Addresses: r.insts2Addrs(apps),
 	}
+	sc, err := r.serviceConfig.Parse(r.cc, r.config)
+	if err != nil {
+		// 无效的配置不下发，继续使用上一次有效的配置
+		r.logger.Error("failed to parse service config", "err", err)
+	}
+	state.ServiceConfig = sc
+	r.cc.UpdateState(state)
 }
 
 func (r *etcdResolver) insts2Addrs(insts map[string]*app.App) []resolver.Address {
 	addrs := make([]resolver.Address, 0, len(insts))
 	for _, v := range insts {
+		if v.Draining() { // 摘流中的实例不再接收新的请求
+			continue
+		}
 		addr := resolver.Address{
 			Addr:       fmt.Sprintf("%s:%d", v.Addr, v.Port),
 			ServerName: v.Name,
@@ -136,6 +176,7 @@ func (r *etcdResolver) insts2Addrs(insts map[string]*app.App) []resolver.Address
 
 		addrs = append(addrs, addr)
 	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
 	return addrs
 }
 
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	PollInterval = time.Second
+	// Logger is used by the file resolvers, logger.Default if nil
+	Logger logger.Logger
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&fileBuilder{})))
+}
+
+type fileBuilder struct{}
+
+// file:///etc/grpc/apps.yaml is an absolute path, file://./apps.json a
+// relative one. The entries can be restricted to one service with
+// ?service=dev/echo, all of them are used otherwise.
+func (b *fileBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	name, service := target.Endpoint, ""
+	if i := strings.Index(name, "?service="); i >= 0 {
+		name, service = name[:i], name[i+len("?service="):]
+	}
+	if target.Authority == "" {
+		name = "/" + name
+	} else {
+		name = path.Join(target.Authority, name)
+	}
+
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
+	r := &fileResolver{
+		cc:      cc,
+		name:    name,
+		service: service,
+		done:    make(chan struct{}),
+		now:     make(chan struct{}, 1),
+		logger:  logger.With(l, "scheme", b.Scheme(), "target", target.Endpoint),
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *fileBuilder) Scheme() string {
+	return "file"
+}
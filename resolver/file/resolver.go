@@ -0,0 +1,118 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fileResolver struct {
+	cc       resolver.ClientConn
+	name     string
+	service  string // env/name
+	done     chan struct{}
+	doneOnce sync.Once
+	now      chan struct{}
+	logger   logger.Logger
+
+	metadata map[string]interface{} // 上一次下发的metadata，见ReuseMetadata
+}
+
+// watch polls the modification time and size of the file every PollInterval
+func (r *fileResolver) watch() {
+	var (
+		modTime time.Time
+		size    int64 = -1
+	)
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		fi, err := os.Stat(r.name)
+		if err != nil {
+			r.logger.Error("failed to resolve addr", "err", err)
+		} else if !fi.ModTime().Equal(modTime) || fi.Size() != size {
+			addrs, err := r.load()
+			if err != nil {
+				r.logger.Error("failed to resolve addr", "err", err)
+			} else {
+				modTime, size = fi.ModTime(), fi.Size()
+				r.cc.UpdateState(resolver.State{
+					Addresses: addrs,
+				})
+			}
+		}
+
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		case <-r.now:
+			size = -1
+		}
+	}
+}
+
+// load reads a json or yaml (by the extension) list of app.App
+func (r *fileResolver) load() ([]resolver.Address, error) {
+	byts, err := ioutil.ReadFile(r.name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(r.name)) {
+	case ".yaml", ".yml":
+		if byts, err = yaml.YAMLToJSON(byts); err != nil {
+			return nil, err
+		}
+	}
+
+	var apps []app.App
+	if err := json.Unmarshal(byts, &apps); err != nil {
+		return nil, fmt.Errorf("file: failed to decode %s: %v", r.name, err)
+	}
+
+	addrs := make([]resolver.Address, 0, len(apps))
+	for i := range apps {
+		a := &apps[i]
+		if r.service != "" && r.service != a.Env+"/"+a.Name {
+			continue
+		}
+		if a.Draining() { // 摘流中的实例不再接收新的请求
+			continue
+		}
+
+		addrs = append(addrs, resolver.Address{
+			Addr:       fmt.Sprintf("%s:%d", a.Addr, a.Port),
+			ServerName: a.Name,
+			Metadata:   &a.Metadata,
+		})
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
+
+	return addrs, nil
+}
+
+func (r *fileResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *fileResolver) Close() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
@@ -0,0 +1,166 @@
+package file
+
+import (
+	"github.com/liuxp0827/grpc-lb/app"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) {
+	cc.states <- s
+}
+
+func (cc *testClientConn) ReportError(error) {}
+
+func (cc *testClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+const appsJSON = `[
+	{"env": "dev", "name": "echo", "addr": "127.0.0.1", "port": 8080, "metadata": {"weight": "100"}},
+	{"env": "dev", "name": "echo", "addr": "127.0.0.1", "port": 8081, "status": "draining"},
+	{"env": "dev", "name": "demo", "addr": "127.0.0.1", "port": 9090}
+]`
+
+const appsYAML = `
+- env: dev
+  name: echo
+  addr: 127.0.0.1
+  port: 8080
+  metadata:
+    weight: "100"
+- env: dev
+  name: echo
+  addr: 127.0.0.1
+  port: 8081
+  status: draining
+- env: dev
+  name: demo
+  addr: 127.0.0.1
+  port: 9090
+`
+
+func addrs(as []resolver.Address) []string {
+	s := make([]string, 0, len(as))
+	for _, a := range as {
+		s = append(s, a.Addr)
+	}
+	return s
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		file    string
+		content string
+		service string
+		addrs   []string
+		err     bool
+	}{
+		{"apps.json", appsJSON, "", []string{"127.0.0.1:8080", "127.0.0.1:9090"}, false},
+		{"apps.json", appsJSON, "dev/echo", []string{"127.0.0.1:8080"}, false},
+		{"apps.yaml", appsYAML, "", []string{"127.0.0.1:8080", "127.0.0.1:9090"}, false},
+		{"apps.yml", appsYAML, "dev/demo", []string{"127.0.0.1:9090"}, false},
+		{"apps.json", appsJSON, "prod/echo", []string{}, false},
+		{"apps.json", appsYAML, "", nil, true},
+		{"apps.yaml", "- env: [", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		name := filepath.Join(dir, tt.file)
+		if err := ioutil.WriteFile(name, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		r := &fileResolver{name: name, service: tt.service}
+		as, err := r.load()
+		if tt.err {
+			if err == nil {
+				t.Errorf("load %s of %s = %v, want an error", tt.file, tt.service, addrs(as))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("load %s of %s: %v", tt.file, tt.service, err)
+			continue
+		}
+		if !reflect.DeepEqual(addrs(as), tt.addrs) {
+			t.Errorf("load %s of %s = %v, want %v", tt.file, tt.service, addrs(as), tt.addrs)
+		}
+		for _, a := range as {
+			if a.Addr == "127.0.0.1:8080" && (*a.Metadata.(*app.Metadata))["weight"] != "100" {
+				t.Errorf("load %s: metadata of %s = %v", tt.file, a.Addr, *a.Metadata.(*app.Metadata))
+			}
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	interval := PollInterval
+	PollInterval = time.Millisecond * 10
+	defer func() { PollInterval = interval }()
+
+	dir, err := ioutil.TempDir("", "file-resolver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "apps.json")
+	if err := ioutil.WriteFile(name, []byte(appsJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := &testClientConn{states: make(chan resolver.State, 10)}
+	r, err := (&fileBuilder{}).Build(resolver.Target{Scheme: "file", Endpoint: name[1:] + "?service=dev/echo"}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	wait := func(want ...string) {
+		t.Helper()
+		select {
+		case s := <-cc.states:
+			if !reflect.DeepEqual(addrs(s.Addresses), want) {
+				t.Fatalf("addrs = %v, want %v", addrs(s.Addresses), want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+	wait("127.0.0.1:8080")
+
+	// 实例结束摘流后重新加载
+	apps := `[
+		{"env": "dev", "name": "echo", "addr": "127.0.0.1", "port": 8080},
+		{"env": "dev", "name": "echo", "addr": "127.0.0.1", "port": 8081}
+	]`
+	if err := ioutil.WriteFile(name, []byte(apps), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait("127.0.0.1:8080", "127.0.0.1:8081")
+
+	// 文件未变化时不更新
+	select {
+	case s := <-cc.states:
+		t.Errorf("unexpected update %v", addrs(s.Addresses))
+	case <-time.After(PollInterval * 5):
+	}
+}
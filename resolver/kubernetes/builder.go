@@ -0,0 +1,76 @@
+package kubernetes
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// AnnotationPrefix selects the pod annotations copied into the address
+	// metadata, with the prefix trimmed, e.g. grpc-lb/weight: "100"
+	AnnotationPrefix = "grpc-lb/"
+	ResyncPeriod     = time.Minute * 5
+	// Logger is used by the kubernetes resolvers, logger.Default if nil
+	Logger logger.Logger
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&k8sBuilder{newClient: newClient})))
+}
+
+// newClient uses the service account of the pod, or $KUBECONFIG outside of the cluster
+func newClient() (kubernetes.Interface, error) {
+	cfg, err := rest.InClusterConfig()
+	if err == rest.ErrNotInCluster {
+		cfg, err = clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+type k8sBuilder struct {
+	newClient func() (kubernetes.Interface, error)
+}
+
+// k8s://namespace/service:port, port is the name or the number of the
+// service port, it may be omitted if the service has a single port.
+func (b *k8sBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	namespace := target.Authority
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	service, port := target.Endpoint, ""
+	if i := strings.LastIndex(service, ":"); i >= 0 {
+		service, port = service[:i], service[i+1:]
+	}
+	if service == "" {
+		return nil, fmt.Errorf("kubernetes: missing service in target %q", target.Endpoint)
+	}
+
+	client, err := b.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	r := newResolver(client, cc, namespace, service, port)
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *k8sBuilder) Scheme() string {
+	return "k8s"
+}
@@ -0,0 +1,292 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ZoneTag = "zone"
+	zoneKey = "topology.kubernetes.io/zone"
+)
+
+type k8sResolver struct {
+	cc        resolver.ClientConn
+	namespace string
+	service   string
+	port      string
+	done      chan struct{}
+	doneOnce  sync.Once
+	changed   chan struct{}
+	logger    logger.Logger
+
+	client    kubernetes.Interface
+	handler   cache.ResourceEventHandler
+	slices    informers.SharedInformerFactory
+	services  informers.SharedInformerFactory
+	sliceLs   discoverylisters.EndpointSliceLister
+	serviceLs corelisters.ServiceLister
+
+	// pods只缓存service的selector选中的pod，selector变化时重建
+	selector string
+	podLs    corelisters.PodLister // service没有selector时为nil
+	stopPods chan struct{}
+
+	metadata map[string]interface{} // 上一次下发的metadata，见ReuseMetadata
+}
+
+func newResolver(client kubernetes.Interface, cc resolver.ClientConn, namespace, service, port string) *k8sResolver {
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
+	r := &k8sResolver{
+		cc:        cc,
+		client:    client,
+		namespace: namespace,
+		service:   service,
+		port:      port,
+		done:      make(chan struct{}),
+		changed:   make(chan struct{}, 1),
+		logger:    logger.With(l, "scheme", "k8s", "target", namespace+"/"+service),
+	}
+
+	r.slices = informers.NewSharedInformerFactoryWithOptions(client, ResyncPeriod,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = discovery.LabelServiceName + "=" + service
+		}))
+	r.services = informers.NewSharedInformerFactoryWithOptions(client, ResyncPeriod,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", service).String()
+		}))
+
+	r.handler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { r.notify() },
+		UpdateFunc: func(interface{}, interface{}) { r.notify() },
+		DeleteFunc: func(interface{}) { r.notify() },
+	}
+
+	sliceInformer := r.slices.Discovery().V1beta1().EndpointSlices()
+	sliceInformer.Informer().AddEventHandler(r.handler)
+	r.sliceLs = sliceInformer.Lister()
+
+	serviceInformer := r.services.Core().V1().Services()
+	serviceInformer.Informer().AddEventHandler(r.handler)
+	r.serviceLs = serviceInformer.Lister()
+
+	return r
+}
+
+// syncPods watches the pods selected by the service, instead of all the pods
+// of the namespace, it is called by watch only
+func (r *k8sResolver) syncPods() {
+	selector := ""
+	if svc, err := r.serviceLs.Services(r.namespace).Get(r.service); err == nil && len(svc.Spec.Selector) > 0 {
+		selector = labels.SelectorFromSet(svc.Spec.Selector).String()
+	}
+	if selector == r.selector {
+		return
+	}
+
+	if r.stopPods != nil {
+		close(r.stopPods)
+		r.stopPods = nil
+	}
+	r.selector, r.podLs = selector, nil
+	if selector == "" {
+		return
+	}
+
+	pods := informers.NewSharedInformerFactoryWithOptions(r.client, ResyncPeriod,
+		informers.WithNamespace(r.namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}))
+	podInformer := pods.Core().V1().Pods()
+	podInformer.Informer().AddEventHandler(r.handler)
+	r.podLs = podInformer.Lister()
+
+	r.stopPods = make(chan struct{})
+	pods.Start(r.stopPods)
+	pods.WaitForCacheSync(r.done)
+}
+
+func (r *k8sResolver) notify() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (r *k8sResolver) watch() {
+	defer func() {
+		if r.stopPods != nil {
+			close(r.stopPods)
+		}
+	}()
+
+	r.slices.Start(r.done)
+	r.services.Start(r.done)
+	r.slices.WaitForCacheSync(r.done)
+	r.services.WaitForCacheSync(r.done)
+	// 没有任何slice时也要更新一次状态
+	r.notify()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.changed:
+		}
+
+		r.syncPods()
+
+		_, span := tracing.Start(context.Background(), "resolver.List", tracing.Resolution("kubernetes", r.namespace+"/"+r.service)...)
+		addrs, err := r.resolve()
+		tracing.Resolved(span, len(addrs), err)
+		if err != nil {
+			r.logger.Error("failed to resolve addr", "err", err)
+			r.cc.ReportError(err)
+			continue
+		}
+		r.cc.UpdateState(resolver.State{
+			Addresses: addrs,
+		})
+	}
+}
+
+func (r *k8sResolver) resolve() ([]resolver.Address, error) {
+	svc, err := r.serviceLs.Services(r.namespace).Get(r.service)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := servicePort(svc.Spec.Ports, r.port)
+	if !ok {
+		return nil, fmt.Errorf("kubernetes: no port %q in service %s/%s", r.port, r.namespace, r.service)
+	}
+
+	slices, err := r.sliceLs.EndpointSlices(r.namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	addrs := make([]resolver.Address, 0)
+	for _, slice := range slices {
+		if slice.AddressType == discovery.AddressTypeFQDN {
+			continue
+		}
+		port, ok := findPort(slice.Ports, name)
+		if !ok {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+
+			md := r.endpointMetadata(ep)
+			for _, ip := range ep.Addresses {
+				key := net.JoinHostPort(ip, strconv.Itoa(int(port)))
+				if seen[key] { // 同一个地址可能出现在多个slice中
+					continue
+				}
+				seen[key] = true
+
+				addrs = append(addrs, resolver.Address{
+					Addr:       key,
+					ServerName: r.service,
+					Metadata:   &md,
+				})
+			}
+		}
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
+
+	return addrs, nil
+}
+
+// servicePort returns the name of the service port named or numbered port,
+// or of the only one if port is empty
+func servicePort(ports []corev1.ServicePort, port string) (string, bool) {
+	for _, p := range ports {
+		if port == "" && len(ports) == 1 || p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			return p.Name, true
+		}
+	}
+	return "", false
+}
+
+// findPort returns the target port of the service port name, the ports of
+// the EndpointSlices are named after the ones of the service
+func findPort(ports []discovery.EndpointPort, name string) (int32, bool) {
+	for _, p := range ports {
+		if p.Port == nil {
+			continue
+		}
+		if p.Name == nil && name == "" || p.Name != nil && *p.Name == name {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// endpointMetadata merges the pod labels, the pod annotations with
+// AnnotationPrefix and the zone of the endpoint
+func (r *k8sResolver) endpointMetadata(ep discovery.Endpoint) app.Metadata {
+	md := app.Metadata{}
+	if zone, ok := ep.Topology[zoneKey]; ok {
+		md[ZoneTag] = zone
+	}
+
+	if ep.TargetRef == nil || ep.TargetRef.Kind != "Pod" || r.podLs == nil {
+		return md
+	}
+	pod, err := r.podLs.Pods(r.namespace).Get(ep.TargetRef.Name)
+	if err != nil {
+		return md
+	}
+
+	for k, v := range pod.Labels {
+		md[k] = v
+	}
+	for k, v := range pod.Annotations {
+		if strings.HasPrefix(k, AnnotationPrefix) {
+			md[strings.TrimPrefix(k, AnnotationPrefix)] = v
+		}
+	}
+	return md
+}
+
+func (r *k8sResolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.notify()
+}
+
+func (r *k8sResolver) Close() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
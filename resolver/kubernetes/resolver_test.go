@@ -0,0 +1,166 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/liuxp0827/grpc-lb/app"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) {
+	cc.states <- s
+}
+
+func (cc *testClientConn) ReportError(error) {}
+
+func (cc *testClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func (cc *testClientConn) wait(t *testing.T, n int) resolver.State {
+	t.Helper()
+	for {
+		select {
+		case s := <-cc.states:
+			if len(s.Addresses) == n {
+				return s
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timeout waiting for %d addresses", n)
+		}
+	}
+}
+
+func pod(name, weight string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Labels:      map[string]string{"app": "echo"},
+			Annotations: map[string]string{AnnotationPrefix + "weight": weight, "unrelated": "x"},
+		},
+	}
+}
+
+func slice(name string, ready bool, ips ...string) *discovery.EndpointSlice {
+	portName, port := "grpc", int32(8080)
+	s := &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels:    map[string]string{discovery.LabelServiceName: "echo"},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Ports:       []discovery.EndpointPort{{Name: &portName, Port: &port}},
+	}
+	for i, ip := range ips {
+		s.Endpoints = append(s.Endpoints, discovery.Endpoint{
+			Addresses:  []string{ip},
+			Conditions: discovery.EndpointConditions{Ready: &ready},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: name + "-" + string(rune('a'+i))},
+			Topology:   map[string]string{zoneKey: "zone-a"},
+		})
+	}
+	return s
+}
+
+func TestResolver(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "echo"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "echo"},
+				Ports:    []corev1.ServicePort{{Name: "grpc", Port: 80, TargetPort: intstr.FromInt(8080)}},
+			},
+		},
+		pod("echo-1-a", "100"),
+		pod("echo-1-b", "50"),
+		slice("echo-1", true, "10.0.0.1", "10.0.0.2"),
+	)
+	b := &k8sBuilder{newClient: func() (kubernetes.Interface, error) { return client, nil }}
+
+	cc := &testClientConn{states: make(chan resolver.State, 10)}
+	r, err := b.Build(resolver.Target{Scheme: "k8s", Authority: "default", Endpoint: "echo:80"}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := cc.wait(t, 2)
+	weights := map[string]string{"10.0.0.1:8080": "100", "10.0.0.2:8080": "50"}
+	for _, addr := range s.Addresses {
+		md := *addr.Metadata.(*app.Metadata)
+		if md["weight"] != weights[addr.Addr] || md[ZoneTag] != "zone-a" || md["app"] != "echo" {
+			t.Errorf("unexpected metadata of %s: %v", addr.Addr, md)
+		}
+		if _, ok := md["unrelated"]; ok {
+			t.Errorf("annotation without prefix copied into metadata of %s", addr.Addr)
+		}
+	}
+
+	// 未就绪的endpoint不返回
+	_, err = client.DiscoveryV1beta1().EndpointSlices("default").Create(context.TODO(), slice("echo-2", false, "10.0.0.3"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.DiscoveryV1beta1().EndpointSlices("default").Update(context.TODO(), slice("echo-1", true, "10.0.0.1"), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = cc.wait(t, 1)
+	if s.Addresses[0].Addr != "10.0.0.1:8080" {
+		t.Errorf("addr = %s, want 10.0.0.1:8080", s.Addresses[0].Addr)
+	}
+}
+
+func TestFindPort(t *testing.T) {
+	grpc, http, p8080, p8081 := "grpc", "http", int32(8080), int32(8081)
+	slicePorts := []discovery.EndpointPort{{Name: &grpc, Port: &p8080}, {Name: &http, Port: &p8081}}
+	servicePorts := []corev1.ServicePort{
+		{Name: "grpc", Port: 80, TargetPort: intstr.FromInt(8080)},
+		{Name: "http", Port: 81, TargetPort: intstr.FromString("http")},
+	}
+
+	empty, p9090 := "", int32(9090)
+	single := []corev1.ServicePort{{Port: 90, TargetPort: intstr.FromInt(9090)}}
+	singleSlice := []discovery.EndpointPort{{Name: &empty, Port: &p9090}}
+
+	tests := []struct {
+		port         string
+		servicePorts []corev1.ServicePort
+		slicePorts   []discovery.EndpointPort
+		want         int32
+		ok           bool
+	}{
+		{"grpc", servicePorts, slicePorts, 8080, true},
+		{"80", servicePorts, slicePorts, 8080, true},
+		{"81", servicePorts, slicePorts, 8081, true},
+		{"8080", servicePorts, slicePorts, 0, false}, // targetPort不是service的端口
+		{"admin", servicePorts, slicePorts, 0, false},
+		{"", servicePorts, slicePorts, 0, false},
+		{"", single, singleSlice, 9090, true},
+		{"90", single, singleSlice, 9090, true},
+	}
+	for _, tt := range tests {
+		got, ok := int32(0), false
+		if name, found := servicePort(tt.servicePorts, tt.port); found {
+			got, ok = findPort(tt.slicePorts, name)
+		}
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("port %q = %d, %v, want %d, %v", tt.port, got, ok, tt.want, tt.ok)
+		}
+	}
+}
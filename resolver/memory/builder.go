@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"google.golang.org/grpc/resolver"
+	"strings"
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&memoryBuilder{})))
+}
+
+type memoryBuilder struct{}
+
+// memory:///dev/echo resolves the apps of memory.DefaultStore,
+// memory://name/dev/echo the ones of the store created as memory.NewStore("name")
+func (b *memoryBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	store, ok := memory.GetStore(target.Authority)
+	if !ok {
+		return nil, fmt.Errorf("memory: no store named %q", target.Authority)
+	}
+
+	i := strings.Index(target.Endpoint, "/")
+	if i <= 0 {
+		return nil, fmt.Errorf("memory: invalid endpoint %q, want env/name", target.Endpoint)
+	}
+
+	r := &memoryResolver{
+		cc:   cc,
+		done: make(chan struct{}),
+	}
+	r.apps, r.cancel = store.Watch(target.Endpoint[:i], target.Endpoint[i+1:])
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *memoryBuilder) Scheme() string {
+	return "memory"
+}
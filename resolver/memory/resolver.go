@@ -0,0 +1,61 @@
+package memory
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"google.golang.org/grpc/resolver"
+	"sync"
+)
+
+type memoryResolver struct {
+	cc       resolver.ClientConn
+	apps     <-chan []app.App
+	cancel   func()
+	done     chan struct{}
+	doneOnce sync.Once
+
+	metadata map[string]interface{} // 上一次下发的metadata，见ReuseMetadata
+}
+
+func (r *memoryResolver) watch() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case apps := <-r.apps:
+			r.cc.UpdateState(resolver.State{
+				Addresses: r.apps2Addrs(apps),
+			})
+		}
+	}
+}
+
+func (r *memoryResolver) apps2Addrs(apps []app.App) []resolver.Address {
+	addrs := make([]resolver.Address, 0, len(apps))
+	for i := range apps {
+		a := &apps[i]
+		if a.Draining() { // 摘流中的实例不再接收新的请求
+			continue
+		}
+
+		addrs = append(addrs, resolver.Address{
+			Addr:       fmt.Sprintf("%s:%d", a.Addr, a.Port),
+			ServerName: a.Name,
+			Metadata:   &a.Metadata,
+		})
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
+
+	return addrs
+}
+
+// ResolveNow does nothing, the store pushes every change
+func (r *memoryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *memoryResolver) Close() {
+	r.doneOnce.Do(func() {
+		r.cancel()
+		close(r.done)
+	})
+}
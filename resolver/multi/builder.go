@@ -0,0 +1,117 @@
+package multi
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"strings"
+	"time"
+)
+
+var (
+	// Logger is used by the multi resolvers, logger.Default if nil
+	Logger logger.Logger
+	// StaleTimeout is how long the last result of a failing source is used,
+	// the source is ignored afterwards until it resolves the target again
+	StaleTimeout = time.Minute * 5
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&multiBuilder{})))
+}
+
+type multiBuilder struct{}
+
+// multi:///dev/echo?sources=etcd://127.0.0.1:2379;consul://127.0.0.1:8500
+// resolves dev/echo with every source, a source with a path resolves that
+// path instead, e.g. k8s://default/echo:grpc. The addresses of the sources
+// are merged, the first source wins for an address found in several ones.
+// A failing source keeps its last addresses for StaleTimeout.
+func (b *multiBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	i := strings.Index(target.Endpoint, "?sources=")
+	if i < 0 {
+		return nil, fmt.Errorf("multi: no sources in %q", target.Endpoint)
+	}
+	endpoint, sources := target.Endpoint[:i], target.Endpoint[i+len("?sources="):]
+
+	var targets []resolver.Target
+	for _, s := range strings.Split(sources, ";") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		t, err := parseSource(s, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("multi: no sources in %q", target.Endpoint)
+	}
+
+	r := &multiResolver{
+		cc:     cc,
+		states: make([]*resolver.State, len(targets)),
+		stale:  make([]*time.Timer, len(targets)),
+	}
+
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
+	// 只要有一个来源可用就继续提供服务
+	var lastErr error
+	for i, t := range targets {
+		// 来源的target已经计入了multi的target，不再经过admin和metrics
+		child, err := unwrap(resolver.Get(t.Scheme)).Build(t, &sourceConn{ClientConn: cc, r: r, i: i}, opts)
+		if err != nil {
+			l.Error("failed to build resolver of source", "target", target.Endpoint, "source", t.Scheme+"://"+t.Authority, "err", err)
+			lastErr = err
+			continue
+		}
+		r.mu.Lock()
+		r.children = append(r.children, child)
+		r.mu.Unlock()
+	}
+	if len(r.children) == 0 {
+		return nil, lastErr
+	}
+
+	return r, nil
+}
+
+func (b *multiBuilder) Scheme() string {
+	return "multi"
+}
+
+// unwrap returns the builder wrapped by admin.Builder and metrics.Builder
+func unwrap(b resolver.Builder) resolver.Builder {
+	for {
+		w, ok := b.(interface{ Unwrap() resolver.Builder })
+		if !ok {
+			return b
+		}
+		b = w.Unwrap()
+	}
+}
+
+// parseSource parses scheme://authority[/endpoint] the way grpc parses targets
+func parseSource(s, endpoint string) (resolver.Target, error) {
+	i := strings.Index(s, "://")
+	if i <= 0 {
+		return resolver.Target{}, fmt.Errorf("multi: invalid source %q", s)
+	}
+	t := resolver.Target{Scheme: s[:i], Endpoint: endpoint}
+	if resolver.Get(t.Scheme) == nil {
+		return resolver.Target{}, fmt.Errorf("multi: unknown scheme of source %q", s)
+	}
+
+	t.Authority = s[i+3:]
+	if j := strings.Index(t.Authority, "/"); j >= 0 {
+		t.Authority, t.Endpoint = t.Authority[:j], t.Authority[j+1:]
+	}
+	return t, nil
+}
@@ -0,0 +1,148 @@
+package multi
+
+import (
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"sync"
+	"time"
+)
+
+type multiResolver struct {
+	cc resolver.ClientConn
+
+	mu       sync.Mutex
+	children []resolver.Resolver
+	states   []*resolver.State // 每个来源最后一次的结果，来源出错时继续使用
+	stale    []*time.Timer     // 来源持续出错StaleTimeout后丢弃它的结果
+	closed   bool
+}
+
+func (r *multiResolver) update(i int, s resolver.State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.states[i] = &s
+	if r.stale[i] != nil {
+		r.stale[i].Stop()
+		r.stale[i] = nil
+	}
+	r.merge()
+}
+
+// merge sends the addresses of all the sources, r.mu is held
+func (r *multiResolver) merge() {
+	merged := resolver.State{Addresses: make([]resolver.Address, 0)}
+	seen := make(map[string]struct{})
+	for _, s := range r.states {
+		if s == nil {
+			continue
+		}
+		for _, addr := range s.Addresses {
+			if _, dup := seen[addr.Addr]; dup {
+				continue
+			}
+			seen[addr.Addr] = struct{}{}
+			merged.Addresses = append(merged.Addresses, addr)
+		}
+		if merged.ServiceConfig == nil {
+			merged.ServiceConfig = s.ServiceConfig
+		}
+	}
+	r.cc.UpdateState(merged)
+}
+
+// reportError reports the error only if no source has resolved the target,
+// the result of the source i is dropped once it fails for StaleTimeout
+func (r *multiResolver) reportError(i int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	if r.states[i] != nil && r.stale[i] == nil {
+		var timer *time.Timer
+		timer = time.AfterFunc(StaleTimeout, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			// 期间来源恢复或者重新开始计时
+			if r.closed || r.stale[i] != timer {
+				return
+			}
+			r.states[i], r.stale[i] = nil, nil
+			if r.resolved() {
+				r.merge()
+			} else {
+				r.cc.ReportError(err)
+			}
+		})
+		r.stale[i] = timer
+	}
+	if !r.resolved() {
+		r.cc.ReportError(err)
+	}
+}
+
+// resolved tells if a source has resolved the target, r.mu is held
+func (r *multiResolver) resolved() bool {
+	for _, s := range r.states {
+		if s != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *multiResolver) ResolveNow(opts resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	children := r.children
+	r.mu.Unlock()
+
+	for _, child := range children {
+		child.ResolveNow(opts)
+	}
+}
+
+func (r *multiResolver) Close() {
+	r.mu.Lock()
+	children := r.children
+	r.closed = true
+	for _, timer := range r.stale {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	r.mu.Unlock()
+
+	for _, child := range children {
+		child.Close()
+	}
+}
+
+// sourceConn is the resolver.ClientConn given to the resolver of a source
+type sourceConn struct {
+	resolver.ClientConn
+	r *multiResolver
+	i int
+}
+
+func (cc *sourceConn) UpdateState(s resolver.State) {
+	cc.r.update(cc.i, s)
+}
+
+func (cc *sourceConn) ReportError(err error) {
+	cc.r.reportError(cc.i, err)
+}
+
+func (cc *sourceConn) NewAddress(addrs []resolver.Address) {
+	cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+func (cc *sourceConn) NewServiceConfig(string) {}
+
+func (cc *sourceConn) ParseServiceConfig(js string) *serviceconfig.ParseResult {
+	return cc.ClientConn.ParseServiceConfig(js)
+}
@@ -0,0 +1,135 @@
+package multi
+
+import (
+	"errors"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"testing"
+	"time"
+
+	_ "github.com/liuxp0827/grpc-lb/resolver/memory"
+	_ "github.com/liuxp0827/grpc-lb/resolver/static"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) {
+	cc.states <- s
+}
+
+func (cc *testClientConn) ReportError(error) {}
+
+func (cc *testClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func (cc *testClientConn) wait(t *testing.T, addrs ...string) resolver.State {
+	t.Helper()
+	for {
+		select {
+		case s := <-cc.states:
+			got := make([]string, 0, len(s.Addresses))
+			for _, addr := range s.Addresses {
+				got = append(got, addr.Addr)
+			}
+			if len(got) == len(addrs) {
+				match := true
+				for i := range addrs {
+					match = match && got[i] == addrs[i]
+				}
+				if match {
+					return s
+				}
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timeout waiting for %v", addrs)
+		}
+	}
+}
+
+func TestMultiResolver(t *testing.T) {
+	s1, s2 := memory.NewStore(t.Name()+"1"), memory.NewStore(t.Name()+"2")
+	r1 := memory.New(memory.WithStore(s1))
+	defer r1.Close()
+	r2 := memory.New(memory.WithStore(s2))
+	defer r2.Close()
+
+	a1 := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080, Metadata: app.Metadata{"source": "1"}}
+	a2 := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8081}
+	r1.Register(a1)
+	r2.Register(a2)
+	r2.Register(app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080, Metadata: app.Metadata{"source": "2"}})
+
+	// 无效的来源被忽略
+	endpoint := "dev/echo?sources=memory://" + s1.Name() + ";memory://" + s2.Name() + ";memory://missing"
+	cc := &testClientConn{states: make(chan resolver.State, 10)}
+	r, err := (&multiBuilder{}).Build(resolver.Target{Scheme: "multi", Endpoint: endpoint}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// 同一地址使用第一个来源的结果
+	source := func() string {
+		s := cc.wait(t, "127.0.0.1:8080", "127.0.0.1:8081")
+		return (*s.Addresses[0].Metadata.(*app.Metadata))["source"]
+	}
+	for source() != "1" {
+	}
+
+	// 来源中的实例消失后使用其余来源的结果
+	s1.Expire(a1)
+	for source() != "2" {
+	}
+
+	if _, err := (&multiBuilder{}).Build(resolver.Target{Scheme: "multi", Endpoint: "dev/echo?sources=memory://missing"}, cc, resolver.BuildOptions{}); err == nil {
+		t.Error("no error without any valid source")
+	}
+
+	// 带路径的来源
+	cc = &testClientConn{states: make(chan resolver.State, 10)}
+	r, err = (&multiBuilder{}).Build(resolver.Target{Scheme: "multi", Endpoint: "dev/echo?sources=static:///10.0.0.1:8080;memory://" + s2.Name()}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cc.wait(t, "10.0.0.1:8080", "127.0.0.1:8080", "127.0.0.1:8081")
+}
+
+func TestStaleSource(t *testing.T) {
+	defer func(timeout time.Duration) { StaleTimeout = timeout }(StaleTimeout)
+	StaleTimeout = time.Millisecond * 50
+
+	s1, s2 := memory.NewStore(t.Name()+"1"), memory.NewStore(t.Name()+"2")
+	r1 := memory.New(memory.WithStore(s1))
+	defer r1.Close()
+	r2 := memory.New(memory.WithStore(s2))
+	defer r2.Close()
+	r1.Register(app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8080})
+	r2.Register(app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: 8081})
+
+	cc := &testClientConn{states: make(chan resolver.State, 10)}
+	r, err := (&multiBuilder{}).Build(resolver.Target{Scheme: "multi", Endpoint: "dev/echo?sources=memory://" + s1.Name() + ";memory://" + s2.Name()}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cc.wait(t, "127.0.0.1:8080", "127.0.0.1:8081")
+
+	// 持续出错的来源超过StaleTimeout后不再使用它的结果
+	r.(*multiResolver).reportError(0, errors.New("unavailable"))
+	cc.wait(t, "127.0.0.1:8081")
+}
+
+func TestUnwrap(t *testing.T) {
+	b := &multiBuilder{}
+	if unwrap(admin.Builder(metrics.Builder(b))) != b {
+		t.Error("builder not unwrapped")
+	}
+}
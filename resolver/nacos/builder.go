@@ -0,0 +1,97 @@
+package nacos
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/backoff"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	internalnacos "github.com/liuxp0827/grpc-lb/internal/nacos"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/nacos-group/nacos-sdk-go/common/constant"
+	"google.golang.org/grpc/resolver"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// EnvAsNamespace resolves the env of the target as the namespace instead of the group
+	EnvAsNamespace = false
+	// Namespace is used unless EnvAsNamespace
+	Namespace = ""
+	// Group is used if EnvAsNamespace
+	Group           = constant.DEFAULT_GROUP
+	ClientConfig    = constant.ClientConfig{TimeoutMs: 5000, NotLoadCacheAtStart: true}
+	BackoffMaxDelay = time.Second * 1
+	// Logger is used by the nacos resolvers, logger.Default if nil
+	Logger logger.Logger
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&nacosBuilder{})))
+}
+
+type nacosBuilder struct{}
+
+// nacos://192.168.50.10:8848,192.168.50.11:8848/dev/echo
+func (b *nacosBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	env, name := "", target.Endpoint
+	if i := strings.Index(name, "/"); i >= 0 {
+		env, name = name[:i], name[i+1:]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("nacos: missing service in target %q", target.Endpoint)
+	}
+
+	namespace, group := Namespace, env
+	if EnvAsNamespace {
+		namespace, group = env, Group
+	}
+	if group == "" {
+		group = constant.DEFAULT_GROUP
+	}
+
+	servers := make([]constant.ServerConfig, 0)
+	for _, addr := range strings.Split(target.Authority, ",") {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.ParseUint(port, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, constant.ServerConfig{IpAddr: host, Port: p})
+	}
+
+	cfg := ClientConfig
+	cfg.NamespaceId = namespace
+	client, err := internalnacos.NamingClient(cfg, servers)
+	if err != nil {
+		return nil, err
+	}
+
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
+	r := &nacosResolver{
+		cc:      cc,
+		client:  client,
+		service: name,
+		group:   group,
+		done:    make(chan struct{}),
+		backoff: metrics.Backoff(b.Scheme(), target.Endpoint, backoff.New(BackoffMaxDelay).Backoff),
+		logger:  logger.With(l, "scheme", b.Scheme(), "target", target.Endpoint),
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *nacosBuilder) Scheme() string {
+	return "nacos"
+}
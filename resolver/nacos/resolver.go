@@ -0,0 +1,169 @@
+package nacos
+
+import (
+	"context"
+	"fmt"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/model"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+	"google.golang.org/grpc/resolver"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WeightTag is the metadata the nacos weight is resolved as
+const WeightTag = "weight"
+
+type nacosResolver struct {
+	cc       resolver.ClientConn
+	client   naming_client.INamingClient
+	service  string
+	group    string
+	done     chan struct{}
+	doneOnce sync.Once
+	backoff  func(int) time.Duration
+	logger   logger.Logger
+
+	mu        sync.Mutex
+	subscribe *vo.SubscribeParam
+	metadata  map[string]interface{} // 上一次下发的metadata，见ReuseMetadata
+}
+
+// watch lists the instances once, then relies on the push of the changes
+func (r *nacosResolver) watch() {
+	retryTimes := 0
+	// SDK在Subscribe失败前已经登记了回调，重试时使用同一个param，失败时取消订阅
+	param := &vo.SubscribeParam{
+		ServiceName:       r.service,
+		GroupName:         r.group,
+		SubscribeCallback: r.update,
+	}
+
+	for {
+		_, span := tracing.Start(context.Background(), "resolver.List", tracing.Resolution("nacos", r.group+"/"+r.service)...)
+		insts, err := r.client.SelectAllInstances(vo.SelectAllInstancesParam{
+			ServiceName: r.service,
+			GroupName:   r.group,
+		})
+		tracing.Resolved(span, len(insts), err)
+		if err == nil {
+			services := make([]model.SubscribeService, 0, len(insts))
+			for _, inst := range insts {
+				services = append(services, model.SubscribeService{
+					Enable:   inst.Enable,
+					Valid:    inst.Healthy,
+					Ip:       inst.Ip,
+					Port:     inst.Port,
+					Weight:   inst.Weight,
+					Metadata: inst.Metadata,
+				})
+			}
+			r.update(services, nil)
+
+			if r.hasClosed() {
+				return
+			}
+			if err = r.client.Subscribe(param); err == nil {
+				// Close期间完成的订阅立即取消
+				r.mu.Lock()
+				if r.hasClosed() {
+					r.client.Unsubscribe(param)
+				} else {
+					r.subscribe = param
+				}
+				r.mu.Unlock()
+			} else {
+				r.client.Unsubscribe(param)
+			}
+		}
+		if err == nil {
+			return
+		}
+
+		r.logger.Error("failed to resolve addr", "err", err)
+		delay := r.backoff(retryTimes)
+		retryTimes++
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *nacosResolver) update(services []model.SubscribeService, err error) {
+	_, span := tracing.Start(context.Background(), "resolver.Watch", tracing.Resolution("nacos", r.group+"/"+r.service)...)
+	defer func() { tracing.Resolved(span, len(services), err) }()
+	if err != nil {
+		r.logger.Error("failed to resolve addr", "err", err)
+		return
+	}
+	if r.hasClosed() {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	addrs := make([]resolver.Address, 0, len(services))
+	for _, svc := range services {
+		// 禁用(摘流中)或不健康的实例不再接收新的请求
+		if !svc.Enable || !svc.Valid {
+			continue
+		}
+
+		md := make(map[string]string, len(svc.Metadata)+1)
+		for k, v := range svc.Metadata {
+			md[k] = v
+		}
+		md[WeightTag] = strconv.Itoa(weight(svc.Weight))
+
+		addrs = append(addrs, resolver.Address{
+			Addr:       fmt.Sprintf("%s:%d", svc.Ip, svc.Port),
+			ServerName: r.service,
+			Metadata:   &md,
+		})
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
+
+	r.cc.UpdateState(resolver.State{
+		Addresses: addrs,
+	})
+}
+
+// weight rounds the nacos weight, the balancers only accept integers
+func weight(w float64) int {
+	if w > 0 && w < 1 {
+		return 1
+	}
+	return int(math.Round(w))
+}
+
+func (r *nacosResolver) hasClosed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+	}
+	return false
+}
+
+func (r *nacosResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *nacosResolver) Close() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+
+		r.mu.Lock()
+		if r.subscribe != nil {
+			r.client.Unsubscribe(r.subscribe)
+			r.subscribe = nil
+		}
+		r.mu.Unlock()
+	})
+}
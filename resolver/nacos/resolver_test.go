@@ -0,0 +1,147 @@
+package nacos
+
+import (
+	"errors"
+	"github.com/liuxp0827/grpc-lb/internal/balancer/smooth_weighted"
+	"github.com/liuxp0827/grpc-lb/internal/tracing/tracingtest"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/model"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+	"google.golang.org/grpc/resolver"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) {
+	cc.states <- s
+}
+
+type testNamingClient struct {
+	naming_client.INamingClient
+	insts []model.Instance
+
+	mu         sync.Mutex
+	subscribed map[*vo.SubscribeParam]bool
+	fails      int // Subscribe登记回调后失败的次数，同SDK
+}
+
+func (c *testNamingClient) SelectAllInstances(vo.SelectAllInstancesParam) ([]model.Instance, error) {
+	return c.insts, nil
+}
+
+func (c *testNamingClient) Subscribe(param *vo.SubscribeParam) error {
+	c.mu.Lock()
+	c.subscribed[param] = true
+	if c.fails > 0 {
+		c.fails--
+		c.mu.Unlock()
+		return errors.New("subscribe failed")
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *testNamingClient) Unsubscribe(param *vo.SubscribeParam) error {
+	c.mu.Lock()
+	delete(c.subscribed, param)
+	c.mu.Unlock()
+	return nil
+}
+
+func TestResolver(t *testing.T) {
+	rec := tracingtest.Install()
+	client := &testNamingClient{
+		insts: []model.Instance{
+			{Ip: "10.0.0.1", Port: 8080, Weight: 1.5, Enable: true, Healthy: true},
+			{Ip: "10.0.0.2", Port: 8080, Weight: 0.4, Enable: true, Healthy: true},
+			{Ip: "10.0.0.3", Port: 8080, Weight: 10, Enable: true, Healthy: true},
+			{Ip: "10.0.0.4", Port: 8080, Weight: 1, Enable: false, Healthy: true},
+		},
+		subscribed: make(map[*vo.SubscribeParam]bool),
+	}
+	cc := &testClientConn{states: make(chan resolver.State, 1)}
+	r := &nacosResolver{
+		cc:      cc,
+		client:  client,
+		service: "echo",
+		group:   "dev",
+		done:    make(chan struct{}),
+		backoff: func(int) time.Duration { return time.Millisecond },
+		logger:  logger.Default,
+	}
+	go r.watch()
+
+	var s resolver.State
+	select {
+	case s = <-cc.states:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for the addresses")
+	}
+
+	spans := rec.Ended("resolver.List")
+	if len(spans) != 1 || spans[0].Attributes["grpc_lb.scheme"].AsString() != "nacos" ||
+		spans[0].Attributes["grpc_lb.target"].AsString() != "dev/echo" || spans[0].Attributes["grpc_lb.addresses"].AsInt64() != 4 {
+		t.Errorf("resolve spans = %+v", spans)
+	}
+
+	// 小数权重取整，否则balancer按无效权重处理
+	want := map[string]int{"10.0.0.1:8080": 2, "10.0.0.2:8080": 1, "10.0.0.3:8080": 10}
+	if len(s.Addresses) != len(want) {
+		t.Fatalf("addresses = %v", s.Addresses)
+	}
+	for _, addr := range s.Addresses {
+		if w := smooth_weighted.Weight(addr); w != want[addr.Addr] {
+			t.Errorf("weight of %s = %d, want %d", addr.Addr, w, want[addr.Addr])
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second * 5); ; time.Sleep(time.Millisecond) {
+		r.mu.Lock()
+		subscribed := r.subscribe != nil
+		r.mu.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the subscription")
+		}
+	}
+	r.Close()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.subscribed) != 0 {
+		t.Errorf("%d subscriptions left after Close", len(client.subscribed))
+	}
+}
+
+func TestSubscribeRetry(t *testing.T) {
+	client := &testNamingClient{
+		insts:      []model.Instance{{Ip: "10.0.0.1", Port: 8080, Weight: 1, Enable: true, Healthy: true}},
+		subscribed: make(map[*vo.SubscribeParam]bool),
+		fails:      3,
+	}
+	r := &nacosResolver{
+		cc:      &testClientConn{states: make(chan resolver.State, 10)},
+		client:  client,
+		service: "echo",
+		group:   "dev",
+		done:    make(chan struct{}),
+		backoff: func(int) time.Duration { return time.Millisecond },
+		logger:  logger.Default,
+	}
+	r.watch()
+	defer r.Close()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.subscribed) != 1 || !client.subscribed[r.subscribe] {
+		t.Errorf("%d subscriptions after the retries, want 1", len(client.subscribed))
+	}
+}
@@ -0,0 +1,64 @@
+package redis
+
+import (
+	goredis "github.com/go-redis/redis/v7"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/backoff"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"path"
+	"time"
+)
+
+// ExpirySuffix names the sorted set of the expiry timestamps of a service,
+// it must match the one of registry/redis
+const ExpirySuffix = "/_expiry"
+
+var (
+	KeyPrefix         = "grpc-discovery"
+	Password          = ""
+	DB                = 0
+	ReconcileInterval = time.Second * 5
+	BackoffMaxDelay   = time.Second * 1
+	// Logger is used by the redis resolvers, logger.Default if nil
+	Logger logger.Logger
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&redisBuilder{})))
+}
+
+type redisBuilder struct{}
+
+// redis://127.0.0.1:6379/dev/echo
+func (b *redisBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	client := goredis.NewClient(&goredis.Options{
+		Addr:     target.Authority,
+		Password: Password,
+		DB:       DB,
+	})
+
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+
+	r := &redisResolver{
+		cc:      cc,
+		client:  client,
+		key:     path.Join(KeyPrefix, target.Endpoint),
+		done:    make(chan struct{}),
+		now:     make(chan struct{}, 1),
+		backoff: metrics.Backoff(b.Scheme(), target.Endpoint, backoff.New(BackoffMaxDelay).Backoff),
+		logger:  logger.With(l, "scheme", b.Scheme(), "target", target.Endpoint),
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *redisBuilder) Scheme() string {
+	return "redis"
+}
@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	goredis "github.com/go-redis/redis/v7"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type redisResolver struct {
+	cc       resolver.ClientConn
+	client   *goredis.Client
+	key      string
+	done     chan struct{}
+	doneOnce sync.Once
+	now      chan struct{}
+	backoff  func(int) time.Duration
+	logger   logger.Logger
+
+	metadata map[string]interface{} // 上一次下发的metadata，见ReuseMetadata
+}
+
+// watch resolves the instances on every message published on the key of the
+// service, after every (re)subscription, and at the latest every
+// ReconcileInterval or when the first instance expires
+func (r *redisResolver) watch() {
+	// 协程退出后才关闭client，避免关闭过程中的请求报错
+	defer r.client.Close()
+	pubsub := r.client.Subscribe(r.key)
+	defer pubsub.Close()
+	msgs := pubsub.ChannelWithSubscriptions(100)
+
+	retryTimes := 0
+	for {
+		wait := ReconcileInterval
+		_, span := tracing.Start(context.Background(), "resolver.List", tracing.Resolution("redis", r.key)...)
+		addrs, next, err := r.resolve()
+		tracing.Resolved(span, len(addrs), err)
+		if err != nil {
+			r.logger.Error("failed to resolve addr", "err", err)
+			r.cc.ReportError(err)
+			wait = r.backoff(retryTimes)
+			retryTimes++
+		} else {
+			retryTimes = 0
+			r.cc.UpdateState(resolver.State{
+				Addresses: addrs,
+			})
+			if d := time.Until(next); !next.IsZero() && d < wait {
+				wait = d + time.Millisecond
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.done:
+			timer.Stop()
+			return
+		case <-msgs:
+			timer.Stop()
+		case <-r.now:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// resolve returns the instances not expired yet, and the time the first of
+// them expires
+func (r *redisResolver) resolve() ([]resolver.Address, time.Time, error) {
+	var next time.Time
+
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	live, err := r.client.ZRangeByScoreWithScores(r.key+ExpirySuffix, &goredis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, next, err
+	}
+	if len(live) == 0 {
+		r.metadata = nil
+		return []resolver.Address{}, next, nil
+	}
+
+	fields := make([]string, 0, len(live))
+	for _, z := range live {
+		fields = append(fields, fmt.Sprint(z.Member))
+	}
+	next = time.Unix(0, int64(live[0].Score)*int64(time.Millisecond))
+
+	vals, err := r.client.HMGet(r.key, fields...).Result()
+	if err != nil {
+		return nil, next, err
+	}
+
+	addrs := make([]resolver.Address, 0, len(vals))
+	for _, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		a := app.App{}
+		a.Decode([]byte(s))
+		if a.Draining() { // 摘流中的实例不再接收新的请求
+			continue
+		}
+
+		addrs = append(addrs, resolver.Address{
+			Addr:       fmt.Sprintf("%s:%d", a.Addr, a.Port),
+			ServerName: a.Name,
+			Metadata:   &a.Metadata,
+		})
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
+
+	return addrs, next, nil
+}
+
+func (r *redisResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *redisResolver) Close() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
@@ -0,0 +1,87 @@
+package static
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"google.golang.org/grpc/resolver"
+	"net"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&staticBuilder{})))
+}
+
+type staticBuilder struct{}
+
+// static:///127.0.0.1:8080?weight=100&zone=a,127.0.0.1:8081?weight=50
+func (b *staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	addrs, err := parse(target.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.UpdateState(resolver.State{
+		Addresses: addrs,
+	})
+
+	return &staticResolver{}, nil
+}
+
+// Target returns the metrics label, the static targets share one series
+// since an address list is not a good label
+func (b *staticBuilder) Target(resolver.Target) string {
+	return ""
+}
+
+func (b *staticBuilder) Scheme() string {
+	return "static"
+}
+
+// parse parses a comma separated list of addresses, each followed by its
+// metadata as a query string
+func parse(endpoint string) ([]resolver.Address, error) {
+	addrs := make([]resolver.Address, 0)
+	for _, s := range strings.Split(endpoint, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		addr, query := s, ""
+		if i := strings.Index(s, "?"); i >= 0 {
+			addr, query = s[:i], s[i+1:]
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("static: invalid address %q: %v", addr, err)
+		}
+
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("static: invalid metadata of %q: %v", addr, err)
+		}
+		md := app.Metadata{}
+		for k := range values {
+			md[k] = values.Get(k)
+		}
+
+		addrs = append(addrs, resolver.Address{
+			Addr:     addr,
+			Metadata: &md,
+		})
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("static: no address in %q", endpoint)
+	}
+	return addrs, nil
+}
+
+// staticResolver never changes the addresses given in the target
+type staticResolver struct{}
+
+func (r *staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *staticResolver) Close() {}
@@ -0,0 +1,60 @@
+package static
+
+import (
+	"github.com/liuxp0827/grpc-lb/app"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		addrs    []string
+		metadata []app.Metadata
+		err      bool
+	}{
+		{
+			endpoint: "127.0.0.1:8080",
+			addrs:    []string{"127.0.0.1:8080"},
+			metadata: []app.Metadata{{}},
+		},
+		{
+			endpoint: "127.0.0.1:8080?weight=100&zone=a, 127.0.0.1:8081?weight=50,",
+			addrs:    []string{"127.0.0.1:8080", "127.0.0.1:8081"},
+			metadata: []app.Metadata{{"weight": "100", "zone": "a"}, {"weight": "50"}},
+		},
+		{
+			endpoint: "[::1]:8080?zone=b",
+			addrs:    []string{"[::1]:8080"},
+			metadata: []app.Metadata{{"zone": "b"}},
+		},
+		{endpoint: "", err: true},
+		{endpoint: " , ", err: true},
+		{endpoint: "127.0.0.1", err: true},
+		{endpoint: "127.0.0.1:8080?weight=%zz", err: true},
+	}
+
+	for _, tt := range tests {
+		addrs, err := parse(tt.endpoint)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parse(%q) = %v, want an error", tt.endpoint, addrs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse(%q): %v", tt.endpoint, err)
+			continue
+		}
+		if len(addrs) != len(tt.addrs) {
+			t.Errorf("parse(%q) = %v, want %v", tt.endpoint, addrs, tt.addrs)
+			continue
+		}
+		for i, addr := range addrs {
+			md := *addr.Metadata.(*app.Metadata)
+			if addr.Addr != tt.addrs[i] || !reflect.DeepEqual(md, tt.metadata[i]) {
+				t.Errorf("parse(%q)[%d] = %s %v, want %s %v", tt.endpoint, i, addr.Addr, md, tt.addrs[i], tt.metadata[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package zookeeper
+
+import (
+	"github.com/go-zookeeper/zk"
+	"github.com/liuxp0827/grpc-lb/internal/admin"
+	"github.com/liuxp0827/grpc-lb/internal/backoff"
+	"github.com/liuxp0827/grpc-lb/internal/metrics"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"path"
+	"strings"
+	"time"
+)
+
+// ConfigKey is the child node of the service path holding its service config,
+// e.g. /grpc-discovery/dev/echo/_config
+const ConfigKey = "_config"
+
+var (
+	PathPrefix      = "/grpc-discovery"
+	BackoffMaxDelay = time.Second * 1
+	SessionTimeout  = time.Second * 10
+	// Logger is used by the zookeeper resolvers, logger.Default if nil
+	Logger logger.Logger
+)
+
+func init() {
+	resolver.Register(admin.Builder(metrics.Builder(&zkBuilder{})))
+}
+
+type zkBuilder struct{}
+
+// zk://192.168.50.10:2181,192.168.50.11:2181,192.168.50.12:2181/dev/echo
+func (b *zkBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	l := Logger
+	if l == nil {
+		l = logger.Default
+	}
+	conn, _, err := zk.Connect(strings.Split(target.Authority, ","), SessionTimeout, zk.WithLogger(logger.Printf(l, logger.LevelInfo)))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &zkResolver{
+		cc:      cc,
+		conn:    conn,
+		path:    path.Join(PathPrefix, target.Endpoint),
+		done:    make(chan struct{}),
+		events:  make(chan string),
+		backoff: metrics.Backoff(b.Scheme(), target.Endpoint, backoff.New(BackoffMaxDelay).Backoff),
+		logger:  logger.With(l, "scheme", b.Scheme(), "target", target.Endpoint),
+	}
+
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *zkBuilder) Scheme() string {
+	return "zk"
+}
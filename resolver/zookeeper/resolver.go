@@ -0,0 +1,212 @@
+package zookeeper
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-zookeeper/zk"
+	"github.com/liuxp0827/grpc-lb/app"
+	internalresolver "github.com/liuxp0827/grpc-lb/internal/resolver"
+	"github.com/liuxp0827/grpc-lb/internal/tracing"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"google.golang.org/grpc/resolver"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+type zkResolver struct {
+	done     chan struct{}
+	doneOnce sync.Once
+	cc       resolver.ClientConn
+	conn     *zk.Conn
+	path     string
+	events   chan string // path of the fired watches
+	backoff  func(int) time.Duration
+	logger   logger.Logger
+	config   string // service config in json
+	watched  bool   // whether the config node has a data watch
+
+	metadata      map[string]interface{}         // 上一次下发的metadata，见ReuseMetadata
+	serviceConfig internalresolver.ServiceConfig // 最后一次有效的服务配置
+}
+
+func (r *zkResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *zkResolver) Close() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+		r.conn.Close()
+	})
+}
+
+// watch keeps a children watch on the service path and a data watch on every
+// child, a fired watch only causes the node it was set on to be read again.
+func (r *zkResolver) watch() {
+	var (
+		apps       = make(map[string]*app.App) // child node -> app
+		pending    = map[string]bool{r.path: true}
+		retryTimes int
+		name       = "resolver.List"
+	)
+
+	for {
+		_, span := tracing.Start(context.Background(), name, tracing.Resolution("zookeeper", r.path)...)
+		if err := r.refresh(apps, pending); err != nil {
+			tracing.Resolved(span, 0, err)
+			r.logger.Error("failed to resolve addr", "err", err)
+			delay := r.backoff(retryTimes)
+			retryTimes++
+			select {
+			case <-r.done:
+				return
+			case p := <-r.events:
+				pending[p] = true
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		retryTimes = 0
+		r.update(apps)
+		tracing.Resolved(span, len(apps), nil)
+		name = "resolver.Watch"
+
+		select {
+		case <-r.done:
+			return
+		case p := <-r.events:
+			pending[p] = true
+		}
+	}
+}
+
+// refresh reads the pending nodes again and sets new watches on them
+func (r *zkResolver) refresh(apps map[string]*app.App, pending map[string]bool) error {
+	for len(pending) > 0 {
+		for p := range pending {
+			if p == r.path {
+				children, _, ch, err := r.conn.ChildrenW(r.path)
+				if err == zk.ErrNoNode {
+					// 服务路径还不存在，等待它被创建
+					_, _, ch, err = r.conn.ExistsW(r.path)
+					if err != nil {
+						return err
+					}
+					for name := range apps {
+						delete(apps, name)
+					}
+					r.config, r.watched = "", false
+				} else if err != nil {
+					return err
+				} else {
+					names := make(map[string]bool, len(children))
+					for _, name := range children {
+						names[name] = true
+						if name == ConfigKey && r.watched {
+							continue
+						}
+						if _, ok := apps[name]; !ok {
+							pending[path.Join(r.path, name)] = true
+						}
+					}
+					for name := range apps {
+						if !names[name] {
+							delete(apps, name)
+						}
+					}
+					if !names[ConfigKey] {
+						r.config, r.watched = "", false
+					}
+				}
+				r.forward(p, ch)
+				delete(pending, p)
+				continue
+			}
+
+			name := path.Base(p)
+			data, _, ch, err := r.conn.GetW(p)
+			if err == zk.ErrNoNode {
+				// 节点已删除，由服务路径上的children watch处理
+				if name == ConfigKey {
+					r.config, r.watched = "", false
+				}
+				delete(apps, name)
+				delete(pending, p)
+				continue
+			} else if err != nil {
+				return err
+			}
+			r.forward(p, ch)
+			delete(pending, p)
+
+			if name == ConfigKey {
+				r.config, r.watched = string(data), true
+				continue
+			}
+			a := app.App{}
+			a.Decode(data)
+			apps[name] = &a
+		}
+	}
+	return nil
+}
+
+func (r *zkResolver) forward(p string, ch <-chan zk.Event) {
+	go func() {
+		select {
+		case <-ch:
+			select {
+			case r.events <- p:
+			case <-r.done:
+			}
+		case <-r.done:
+		}
+	}()
+}
+
+func (r *zkResolver) update(apps map[string]*app.App) {
+	state := resolver.State{
+		Addresses: r.insts2Addrs(apps),
+	}
+	sc, err := r.serviceConfig.Parse(r.cc, r.config)
+	if err != nil {
+		// 无效的配置不下发，继续使用上一次有效的配置
+		r.logger.Error("failed to parse service config", "err", err)
+	}
+	state.ServiceConfig = sc
+	r.cc.UpdateState(state)
+}
+
+func (r *zkResolver) insts2Addrs(insts map[string]*app.App) []resolver.Address {
+	// 会话过期后重新注册的节点可能与旧节点同时存在，按序号取最新的一个
+	names := make([]string, 0, len(insts))
+	for name := range insts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	latest := make(map[string]*app.App, len(insts))
+	for _, name := range names {
+		v := insts[name]
+		latest[fmt.Sprintf("%s:%d", v.Addr, v.Port)] = v
+	}
+
+	addrs := make([]resolver.Address, 0, len(latest))
+	for key, v := range latest {
+		if v.Draining() { // 摘流中的实例不再接收新的请求
+			continue
+		}
+		addr := resolver.Address{
+			Addr:       key,
+			ServerName: v.Name,
+		}
+
+		// the addr.Metadata will be hashed, so we should use pointer
+		addr.Metadata = &v.Metadata
+
+		addrs = append(addrs, addr)
+	}
+	r.metadata = internalresolver.ReuseMetadata(r.metadata, addrs)
+	return addrs
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/app/advertise"
+	"github.com/liuxp0827/grpc-lb/logger"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"google.golang.org/grpc"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// set how long to wait after the deregistration for the clients to see it,
+// 5s by default
+func WithPropagationDelay(d time.Duration) Option {
+	return func(opts *Options) {
+		opts.delay = d
+	}
+}
+
+// set how long to wait for the in-flight rpcs to complete before they are
+// cancelled, forever by default
+func WithDrainTimeout(d time.Duration) Option {
+	return func(opts *Options) {
+		opts.drainTimeout = d
+	}
+}
+
+// set the signals to shut down on, SIGINT and SIGTERM by default
+func WithSignals(sigs ...os.Signal) Option {
+	return func(opts *Options) {
+		opts.signals = sigs
+	}
+}
+
+// set how to detect the address of the app when it has none, the port is
+// the one of the listener
+func WithAdvertise(advOpts ...advertise.Option) Option {
+	return func(opts *Options) {
+		opts.advertise = advOpts
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(opts *Options) {
+		opts.l = l
+	}
+}
+
+type Option func(opts *Options)
+type Options struct {
+	delay        time.Duration
+	drainTimeout time.Duration
+	signals      []os.Signal
+	advertise    []advertise.Option
+	l            logger.Logger
+}
+
+// Server ties the lifecycle of a grpc server to the registration of its app
+type Server struct {
+	s            *grpc.Server
+	r            registry.Registry
+	a            app.App
+	opts         *Options
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+func New(s *grpc.Server, r registry.Registry, a app.App, opts ...Option) *Server {
+	srv := &Server{
+		s:        s,
+		r:        r,
+		a:        a,
+		opts:     new(Options),
+		shutdown: make(chan struct{}),
+	}
+
+	srv.opts.delay = time.Second * 5
+	for _, opt := range opts {
+		opt(srv.opts)
+	}
+
+	if len(srv.opts.signals) == 0 {
+		srv.opts.signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+
+	if srv.opts.l == nil {
+		srv.opts.l = logger.Default
+	}
+
+	return srv
+}
+
+// Serve serves the listener and registers the app once the server accepts
+// connections, with the address and port detected by advertise.Fill if
+// unset. On a signal or Shutdown, the app is deregistered first, then the
+// server stops after the propagation delay and the in-flight rpcs.
+// The error of the registration (e.g. registry.ErrFailedRenew) or of the
+// server is returned, nil after a shutdown.
+func (s *Server) Serve(lis net.Listener) error {
+	if err := advertise.Fill(&s.a, append([]advertise.Option{advertise.FromListener(lis)}, s.opts.advertise...)...); err != nil {
+		return err
+	}
+
+	l := &listener{Listener: lis, accepting: make(chan struct{})}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.s.Serve(l)
+	}()
+
+	select {
+	case <-l.accepting:
+	case err := <-serveErr:
+		return err
+	}
+
+	errCh := s.r.Register(s.a)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, s.opts.signals...)
+	defer signal.Stop(sig)
+
+	select {
+	case <-sig:
+	case <-s.shutdown:
+	case err := <-errCh:
+		// 注册失败或者续租失败，客户端已经无法发现本实例
+		s.opts.l.Error("failed to register", "addr", fmt.Sprintf("%s:%d", s.a.Addr, s.a.Port), "err", err)
+		s.r.Close()
+		s.stop()
+		return err
+	case err := <-serveErr:
+		s.r.Close()
+		return err
+	}
+
+	// 先注销，等待客户端感知后再停止服务
+	s.r.Close()
+	time.Sleep(s.opts.delay)
+	s.stop()
+	return nil
+}
+
+// Run serves the listener like Serve and exits the process with status 1
+// on error
+func (s *Server) Run(lis net.Listener) {
+	if err := s.Serve(lis); err != nil {
+		s.opts.l.Error("server exits", "addr", fmt.Sprintf("%s:%d", s.a.Addr, s.a.Port), "err", err)
+		os.Exit(1)
+	}
+}
+
+// Shutdown makes Serve deregister the app and stop the server as on a signal
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+	})
+}
+
+func (s *Server) stop() {
+	done := make(chan struct{})
+	go func() {
+		s.s.GracefulStop()
+		close(done)
+	}()
+
+	if s.opts.drainTimeout <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(s.opts.drainTimeout):
+		s.s.Stop()
+	}
+}
+
+// listener tells when the server starts accepting connections
+type listener struct {
+	net.Listener
+	once      sync.Once
+	accepting chan struct{}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	l.once.Do(func() {
+		close(l.accepting)
+	})
+	return l.Listener.Accept()
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"github.com/liuxp0827/grpc-lb/app"
+	"github.com/liuxp0827/grpc-lb/registry"
+	"github.com/liuxp0827/grpc-lb/registry/memory"
+	"google.golang.org/grpc"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, store *memory.Store, opts ...Option) (*Server, app.App, chan error) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := app.App{Env: "dev", Name: "echo", Addr: "127.0.0.1", Port: lis.Addr().(*net.TCPAddr).Port}
+	r := memory.New(memory.WithStore(store), memory.WithTTL(time.Millisecond*30))
+
+	s := New(grpc.NewServer(), r, a, opts...)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Serve(lis)
+	}()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for len(store.Apps("dev", "echo")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("app not registered")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return s, a, errCh
+}
+
+func TestShutdown(t *testing.T) {
+	store := memory.NewStore(t.Name())
+	s, _, errCh := serve(t, store, WithPropagationDelay(time.Millisecond*100))
+
+	start := time.Now()
+	s.Shutdown()
+	time.Sleep(time.Millisecond * 50)
+	if len(store.Apps("dev", "echo")) != 0 {
+		t.Error("app not deregistered before the server stops")
+	}
+
+	if err := <-errCh; err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if d := time.Since(start); d < time.Millisecond*100 {
+		t.Errorf("server stopped after %s, before the propagation delay", d)
+	}
+}
+
+func TestFailedRenew(t *testing.T) {
+	store := memory.NewStore(t.Name())
+	_, a, errCh := serve(t, store)
+
+	store.SetHooks(memory.Hooks{Renew: func(app.App) error { return errors.New("partitioned") }})
+	select {
+	case err := <-errCh:
+		if err != registry.ErrFailedRenew {
+			t.Errorf("err = %v, want %v", err, registry.ErrFailedRenew)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("server not stopped")
+	}
+
+	if conn, err := net.Dial("tcp", net.JoinHostPort(a.Addr, strconv.Itoa(a.Port))); err == nil {
+		conn.Close()
+		t.Error("server still accepts connections")
+	}
+}